@@ -3,19 +3,37 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"image"
+	"image/png"
 	"io"
 	"log"
+	"math"
+	"math/rand"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/tebeka/selenium"
 	"github.com/tebeka/selenium/chrome"
 	"github.com/tebeka/selenium/firefox"
+	seleniumlog "github.com/tebeka/selenium/log"
 )
 
 // Step defines a single action in the JSON steps
@@ -36,29 +54,267 @@ type Step struct {
 	Message         string                 `json:"message,omitempty"`
 	ExpectedValue   string                 `json:"expected_value,omitempty"`
 	ElementSelector string                 `json:"element_selector,omitempty"`
+	By              string                 `json:"by,omitempty"`
+	Retries         int                    `json:"retries,omitempty"`
+	RetryDelay      float64                `json:"retry_delay,omitempty"`
+	Condition       *Condition             `json:"condition,omitempty"`
+	Description     string                 `json:"description,omitempty"`
+	Tags            []string               `json:"tags,omitempty"`
+	OnlyOn          []string               `json:"only_on,omitempty"`
+	SkipOn          []string               `json:"skip_on,omitempty"`
+
+	// Source records where the step was read from as "file:line" and Path its position within that
+	// file, e.g. "step[3]" or "step[3].then[1]" for nested steps; both are used in error messages
+	Source string `json:"-"`
+	Path   string `json:"-"`
+}
+
+// Condition makes a step run only if variable Var compares to Value with Op, one of "equals",
+// "not_equals", "contains" or "exists" (which ignores Value)
+type Condition struct {
+	Var   string `json:"var"`
+	Op    string `json:"op"`
+	Value string `json:"value,omitempty"`
 }
 
 // JSONData represents the entire JSON structure
 type JSONData []Step
 
+// Build information, set at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+//
+// Builds without ldflags fall back to the module and VCS data embedded by the Go toolchain.
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+// printVersion writes the version, commit and build date of this binary and the selenium module
+// it was built against
+func printVersion(w io.Writer) {
+	ver, rev, built, seleniumVersion := version, commit, date, "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if ver == "" {
+			ver = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && rev == "":
+				rev = setting.Value
+			case setting.Key == "vcs.time" && built == "":
+				built = setting.Value
+			}
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/tebeka/selenium" {
+				seleniumVersion = dep.Version
+			}
+		}
+	}
+	for _, v := range []*string{&ver, &rev, &built} {
+		if *v == "" {
+			*v = "unknown"
+		}
+	}
+	fmt.Fprintf(w, "seleniumctl %s\ncommit: %s\nbuilt: %s\ntebeka/selenium: %s\n", ver, rev, built, seleniumVersion)
+}
+
+// StepResult records the outcome of a single step for machine-readable output
+type StepResult struct {
+	Index       int    `json:"index"`
+	Action      string `json:"action"`
+	Description string `json:"description,omitempty"`
+	Location    string `json:"location,omitempty"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	DurationMs  int64  `json:"duration_ms"`
+	// Variables holds the variables the step set or changed
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+// resultRecorder streams step results as JSON lines and/or collects them into a JSON array that
+// is written when the run ends
+type resultRecorder struct {
+	out     io.Writer
+	stream  bool
+	collect bool
+	results []StepResult
+}
+
+// Record streams or collects result
+func (r *resultRecorder) Record(result StepResult) {
+	if r.stream {
+		if err := json.NewEncoder(r.out).Encode(result); err != nil {
+			log.Printf("Failed to stream result of step %d: %v", result.Index, err)
+		}
+	}
+	if r.collect {
+		r.results = append(r.results, result)
+	}
+}
+
+// Flush writes the collected results, if collecting
+func (r *resultRecorder) Flush() {
+	if !r.collect {
+		return
+	}
+	results := r.results
+	if results == nil {
+		results = []StepResult{}
+	}
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		log.Printf("Failed to write results: %v", err)
+		return
+	}
+	if _, err := r.out.Write(append(data, '\n')); err != nil {
+		log.Printf("Failed to write results: %v", err)
+	}
+}
+
+// changedVariables returns the variables in after that are new or differ from before
+func changedVariables(before, after map[string]string) map[string]string {
+	var changed map[string]string
+	for name, value := range after {
+		if old, ok := before[name]; ok && old == value {
+			continue
+		}
+		if changed == nil {
+			changed = map[string]string{}
+		}
+		changed[name] = value
+	}
+	return changed
+}
+
+// console receives the human-readable progress output. It is switched to stderr when stdout
+// carries machine-readable results.
+var console io.Writer = os.Stdout
+
 // Context holds the Selenium WebDriver and other runtime data
 type Context struct {
 	WebDriver selenium.WebDriver
 	Variables map[string]string
+	// Browser is the name of the browser being driven, e.g. "chrome"
+	Browser string
+	// URL is the base URL of the WebDriver server, used for commands the selenium package doesn't wrap
+	URL string
+	// NotFoundScreenshotDir, when set, is where findElement saves a screenshot on timeout
+	NotFoundScreenshotDir string
+	// KeepAlive is the interval at which long waits ping the session, disabled when zero
+	KeepAlive time.Duration
+	// Humanizer randomizes the timing of clicks and typing, nil unless -humanize is set
+	Humanizer *Humanizer
+	// DefaultTimeout is the timeout in seconds of steps that don't set one
+	DefaultTimeout int
+	// BaseURL, when set, is what relative step URLs are resolved against
+	BaseURL *url.URL
+	// Masked names variables whose values are hidden in output, in addition to secret_ ones
+	Masked map[string]bool
+	// DriverLock serializes use of the WebDriver between the step loop and background recorders
+	DriverLock sync.Mutex
+	// FailedRequests holds the 4xx and 5xx responses read from the performance log since the
+	// previous assert_no_failed_requests, at most maxFailedRequests of them. Reading the log drains
+	// it, so they are kept here whichever action read them.
+	FailedRequests []failedRequest
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run executes the steps and returns the exit code. Once the browser is started errors are
+// returned instead of being fatal, so the deferred cleanup quits the browser and driver service.
+func run() int {
 	// Define command-line flags
+	continueOnErrorFlag := flag.Bool("continue-on-error", false, "Run all steps even after failures, then print a summary and exit non-zero if any failed")
+	pauseOnErrorFlag := flag.Bool("pause-on-error", false, "When a step fails, wait for Enter before closing the browser so the page can be inspected")
+	keepOpenFlag := flag.Bool("keep-open", false, "Leave the browser and WebDriver running when done, regardless of -close")
+	versionFlag := flag.Bool("version", false, "Print version and build information and exit")
 	browserFlag := flag.String("browser", "firefox", "Browser to use (firefox, chrome, edge)")
 	webdriverPathFlag := flag.String("webdriver-path", "", "Path to the WebDriver executable (overrides default PATH lookup)")
+	browserBinaryFlag := flag.String("browser-binary", "", "Path to the browser executable to launch (overrides the driver's default)")
 	headlessFlag := flag.Bool("headless", false, "Run browser in headless mode")
 	windowWidthFlag := flag.Int("window-width", 1280, "Width of the browser window")
 	windowHeightFlag := flag.Int("window-height", 800, "Height of the browser window")
 	timeoutFlag := flag.Int("default-timeout", 30, "Default timeout in seconds for actions")
 	portFlag := flag.Int("port", 13337, "Default port for webdriver service")
 	closeBrowserFlag := flag.Bool("close", false, "Close the browser after execution")
+	screenshotEachStepFlag := flag.String("screenshot-each-step", "", "Directory to write a screenshot to after every step (disabled when empty)")
+	dumpVarsFlag := flag.String("dump-vars", "", "File to write the captured variables to as JSON when the run ends")
+	varsFileFlag := flag.String("vars-file", "", "JSON file of variables to preload, e.g. one written by -dump-vars")
+	autoNoSandboxFlag := flag.Bool("auto-no-sandbox", true, "Pass --no-sandbox to Chrome when running as root inside a container")
+	autoPortFlag := flag.Bool("auto-port", false, "Use a free ephemeral port if -port is already in use")
+	serviceStartTimeoutFlag := flag.Int("service-start-timeout", 20, "Seconds to retry the session request until the WebDriver server reports ready, after a local driver's own startup check")
+	notFoundScreenshotsFlag := flag.String("not-found-screenshots", "", "Directory to save a screenshot to when an element is not found, linked from the error")
+	keepAliveFlag := flag.Int("keepalive", 0, "Seconds between session pings during long waits, to keep remote sessions alive (0 disables)")
+	failOnConsoleFlag := flag.String("fail-on-console", "", "Fail the run if a browser console message matches this regex (chrome and edge only)")
+	humanizeFlag := flag.Bool("humanize", false, "Add random delays before clicks and between typed characters")
+	humanizeMinFlag := flag.Int("humanize-min", 50, "Minimum -humanize delay in milliseconds")
+	humanizeMaxFlag := flag.Int("humanize-max", 250, "Maximum -humanize delay in milliseconds")
+	humanizeSeedFlag := flag.Int64("humanize-seed", 0, "Seed for the -humanize delays, random when 0; the seed used is logged for reproduction")
+	recordFlag := flag.String("record", "", "Directory to write numbered screenshots to at a fixed interval during the run")
+	recordIntervalFlag := flag.Int("record-interval", 200, "Milliseconds between -record frames")
+	remoteURLFlag := flag.String("remote-url", "", "URL of a running WebDriver server or Selenium Grid hub to connect to instead of starting a local driver, e.g. http://grid:4444/wd/hub")
+	baseURLFlag := flag.String("base-url", "", "Base URL that relative step URLs like \"/login\" are resolved against")
+	precheckURLFlag := flag.String("precheck-url", "", "URL to GET before starting the browser, failing fast if the target is unreachable")
+	urlFlag := flag.String("url", "", "HTTP(S) URL to fetch the step JSON from instead of stdin")
+	checkFlag := flag.Bool("check", false, "Only parse the steps and check their actions are known, then exit without starting a browser")
+	outputFlag := flag.String("output", "text", "Output format: text prints progress, json writes an array of step results with captured variables to stdout at the end")
+	streamResultsFlag := flag.Bool("stream-results", false, "Write one JSON result per step to stdout as it completes; progress output moves to stderr")
+	fmtFlag := flag.Bool("fmt", false, "Print the steps in canonical formatting and exit")
+	writeFlag := flag.Bool("w", false, "With -fmt, rewrite the step files in place instead of printing")
+	errorPolicyFlag := flag.String("error-policy", "fail-fast", "How to handle step errors: fail-fast aborts on any error, tolerate-actions aborts on assertion failures and invalid steps only and continues after operational errors")
+	actionRetriesFlag := flag.Int("action-retries", 0, "Times to retry a step failing with an operational error under -error-policy tolerate-actions, unless the step sets its own 'retries'")
+	tagsFlag := flag.String("tags", "", "Only run steps whose tags match, e.g. \"smoke,login+!slow\" (',' is OR, '+' is AND, '!' is NOT)")
+	var maskFlag stringList
+	flag.Var(&maskFlag, "mask", "Variable whose value is replaced with *** in all output; repeat or comma-separate (secret_ variables are always masked)")
+	proxyFlag := flag.String("proxy", "", "Proxy for the browser's traffic: http://host:port, socks5://host:port or the URL of a PAC file")
+	var proxyBypassFlag stringList
+	flag.Var(&proxyBypassFlag, "proxy-bypass", "Hosts to connect to without the proxy; repeat or comma-separate")
+	capsFileFlag := flag.String("caps-file", "", "JSON file of W3C capabilities, e.g. platformName or se:options, merged over the ones derived from the other flags")
+	profileDirFlag := flag.String("profile-dir", "", "Browser profile directory to use and keep changes in, e.g. to stay logged in between runs")
+	var extensionFlag stringList
+	flag.Var(&extensionFlag, "extension", "Browser extension to load (.crx or unpacked directory for Chrome and Edge, .xpi for Firefox); repeat or comma-separate")
+	var fileFlag stringList
+	flag.Var(&fileFlag, "file", "Step file to run instead of stdin; repeat or comma-separate to concatenate several files in order. Step files may also be given as arguments")
 	flag.Parse()
 
+	if *versionFlag {
+		printVersion(os.Stdout)
+		return 0
+	}
+
+	if *outputFlag != "text" && *outputFlag != "json" {
+		log.Fatalf("Invalid -output '%s', expected text or json", *outputFlag)
+	}
+	if *outputFlag == "json" && *streamResultsFlag {
+		log.Fatalf("-output json and -stream-results cannot be combined")
+	}
+	if *streamResultsFlag {
+		console = os.Stderr
+	}
+	if *outputFlag == "json" {
+		console = io.Discard
+	}
+
+	if flag.NArg() > 0 {
+		if len(fileFlag) > 0 {
+			log.Printf("Warning: both -file and a path argument given, ignoring %s", strings.Join(flag.Args(), " "))
+		} else {
+			fileFlag = flag.Args()
+		}
+	}
+
+	if *fmtFlag {
+		if err := formatFiles(fileFlag, *writeFlag); err != nil {
+			log.Fatalf("Failed to format steps: %v", err)
+		}
+		return 0
+	}
+
 	// Validate browser flag
 	supportedBrowsers := map[string]bool{
 		"firefox": true,
@@ -70,14 +326,126 @@ func main() {
 		log.Fatalf("Unsupported browser: %s. Supported browsers are: firefox, chrome, edge.", browser)
 	}
 
-	// Read JSON from stdin
-	jsonData, err := readJSONFromStdin()
-	if err != nil {
-		log.Fatalf("Failed to read JSON from stdin: %v", err)
+	var jsonData JSONData
+	var err error
+	if len(fileFlag) > 0 && *urlFlag != "" {
+		log.Fatalf("-file and -url cannot be combined")
+	}
+	if *urlFlag != "" {
+		jsonData, err = readJSONFromURL(*urlFlag, time.Duration(*timeoutFlag)*time.Second)
+		if err != nil {
+			log.Fatalf("Failed to read JSON from URL: %v", err)
+		}
+	} else if len(fileFlag) > 0 {
+		jsonData, err = readJSONFromFiles(fileFlag)
+		if err != nil {
+			log.Fatalf("Failed to read JSON from files: %v", err)
+		}
+	} else {
+		// Read JSON from stdin
+		jsonData, err = readJSONFromStdin()
+		if err != nil {
+			log.Fatalf("Failed to read JSON from stdin: %v", err)
+		}
+	}
+
+	if *checkFlag {
+		if problems := checkActions(jsonData); len(problems) > 0 {
+			for _, problem := range problems {
+				log.Print(problem)
+			}
+			log.Fatalf("%d problems found in the steps", len(problems))
+		}
+		fmt.Fprintf(console, "%d steps parsed successfully.\n", len(jsonData))
+		return 0
+	}
+
+	var tags tagExpression
+	if *tagsFlag != "" {
+		if tags, err = parseTagExpression(*tagsFlag); err != nil {
+			log.Fatalf("Invalid -tags expression: %v", err)
+		}
+	}
+	if *errorPolicyFlag != "fail-fast" && *errorPolicyFlag != "tolerate-actions" {
+		log.Fatalf("Invalid -error-policy '%s', expected fail-fast or tolerate-actions", *errorPolicyFlag)
+	}
+	tolerateActions := *errorPolicyFlag == "tolerate-actions"
+	var consolePattern *regexp.Regexp
+	var consoleFailures []string
+	if *failOnConsoleFlag != "" {
+		if consolePattern, err = regexp.Compile(*failOnConsoleFlag); err != nil {
+			log.Fatalf("Invalid -fail-on-console pattern: %v", err)
+		}
+		if !chromium(browser) {
+			log.Fatalf("-fail-on-console needs the browser log, which is only available in chrome and edge, not %s", browser)
+		}
+	}
+
+	variables := make(map[string]string)
+	if *varsFileFlag != "" {
+		if variables, err = loadVariables(*varsFileFlag); err != nil {
+			log.Fatalf("Failed to load variables: %v", err)
+		}
+	}
+
+	var baseURL *url.URL
+	if *baseURLFlag != "" {
+		if baseURL, err = url.Parse(*baseURLFlag); err != nil {
+			log.Fatalf("Invalid -base-url: %v", err)
+		}
+	}
+
+	if *precheckURLFlag != "" {
+		target, err := resolveURL(&Context{BaseURL: baseURL}, *precheckURLFlag)
+		if err != nil {
+			log.Fatalf("Invalid -precheck-url: %v", err)
+		}
+		if err := precheckURL(target, time.Duration(*timeoutFlag)*time.Second); err != nil {
+			log.Fatalf("Pre-check failed, target is not reachable: %v", err)
+		}
+	}
+
+	var proxy *selenium.Proxy
+	if *proxyFlag != "" {
+		if proxy, err = parseProxy(*proxyFlag, proxyBypassFlag); err != nil {
+			log.Fatalf("Invalid -proxy: %v", err)
+		}
+	}
+	var extraCaps map[string]interface{}
+	if *capsFileFlag != "" {
+		if extraCaps, err = loadCapabilities(*capsFileFlag); err != nil {
+			log.Fatalf("Failed to load capabilities: %v", err)
+		}
+	}
+
+	remoteURL := strings.TrimSuffix(*remoteURLFlag, "/")
+	port := *portFlag
+	if *autoPortFlag && remoteURL == "" {
+		if port, err = availablePort(port); err != nil {
+			log.Fatalf("Failed to find a free port: %v", err)
+		}
 	}
 
 	// Initialize Selenium WebDriver
-	wd, service, err := initializeWebDriver(browser, *webdriverPathFlag, *headlessFlag, *windowWidthFlag, *windowHeightFlag, *timeoutFlag, *portFlag)
+	wd, service, err := initializeWebDriver(DriverOptions{
+		Browser:       browser,
+		WebDriverPath: *webdriverPathFlag,
+		Headless:      *headlessFlag,
+		Width:         *windowWidthFlag,
+		Height:        *windowHeightFlag,
+		Timeout:       *timeoutFlag,
+		Port:          port,
+		AutoNoSandbox: *autoNoSandboxFlag,
+		StartTimeout:  time.Duration(*serviceStartTimeoutFlag) * time.Second,
+		BrowserBinary: *browserBinaryFlag,
+		RemoteURL:     remoteURL,
+		ProfileDir:    *profileDirFlag,
+		Extensions:    extensionFlag,
+		ExtraCaps:     extraCaps,
+		Proxy:         proxy,
+		NetworkEvents: usesActions(jsonData, networkActions),
+		ConsoleLog:    consolePattern != nil,
+	})
 	if err != nil || wd == nil {
 		log.Fatalf("Failed to initialize WebDriver: %v", err)
 	}
@@ -85,6 +453,10 @@ func main() {
 		if wd == nil {
 			return
 		}
+		if *keepOpenFlag {
+			log.Printf("Leaving the browser open (-keep-open)")
+			return
+		}
 		if *closeBrowserFlag {
 			if err := wd.Quit(); err != nil {
 				log.Printf("Error quitting WebDriver: %v", err)
@@ -95,20 +467,187 @@ func main() {
 		}
 	}()
 
+	webDriverURL := remoteURL
+	if webDriverURL == "" {
+		webDriverURL = fmt.Sprintf("http://127.0.0.1:%d", port)
+	}
 	ctx := &Context{
 		WebDriver: wd,
-		Variables: make(map[string]string),
+		Variables: variables,
+		Browser:   browser,
+		URL:       webDriverURL,
+
+		NotFoundScreenshotDir: *notFoundScreenshotsFlag,
+		KeepAlive:             time.Duration(*keepAliveFlag) * time.Second,
+		BaseURL:               baseURL,
+		DefaultTimeout:        *timeoutFlag,
 	}
 
+	for _, dir := range []string{*screenshotEachStepFlag, *notFoundScreenshotsFlag} {
+		if dir == "" {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Printf("Failed to create screenshot directory: %v", err)
+			return 1
+		}
+	}
+
+	if *humanizeFlag {
+		seed := *humanizeSeedFlag
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		log.Printf("Humanizing interactions with seed %d", seed)
+		ctx.Humanizer = NewHumanizer(time.Duration(*humanizeMinFlag)*time.Millisecond, time.Duration(*humanizeMaxFlag)*time.Millisecond, seed)
+	}
+
+	ctx.Masked = make(map[string]bool)
+	for _, name := range maskFlag {
+		ctx.Masked[name] = true
+	}
+	log.SetOutput(&maskingWriter{ctx: ctx, w: log.Writer()})
+	console = &maskingWriter{ctx: ctx, w: console}
+
+	results := &resultRecorder{
+		out:     &maskingWriter{ctx: ctx, w: os.Stdout},
+		stream:  *streamResultsFlag,
+		collect: *outputFlag == "json",
+	}
+
+	// Started last, the recorder shares ctx and the log writer with the step loop
+	if *recordFlag != "" {
+		stopRecording, err := startRecording(ctx, *recordFlag, time.Duration(*recordIntervalFlag)*time.Millisecond)
+		if err != nil {
+			log.Printf("Failed to start recording: %v", err)
+			return 1
+		}
+		defer stopRecording()
+	}
+
+	// failed counts failed steps; failures may also hold errors that aren't a step's
+	skipped, tolerated, failed := 0, 0, 0
+	var failures []error
+
 	// Execute each step
 	for idx, step := range jsonData {
-		fmt.Printf("Executing step %d: %s\n", idx, step.Action)
-		if err := executeStep(ctx, step); err != nil {
-			log.Fatalf("Error executing step %d (%s): %v", idx, step.Action, err)
+		reason := browserSkipReason(ctx, step)
+		if tags != nil && !tags.Match(step.Tags) {
+			reason = fmt.Sprintf("tags %v do not match -tags", step.Tags)
+		}
+		if reason != "" {
+			fmt.Fprintf(console, "Skipping step %d: %s (%s)\n", idx, stepLabel(step), reason)
+			skipped++
+			results.Record(newSkippedResult(idx, step))
+			continue
+		}
+		fmt.Fprintf(console, "Executing step %d: %s\n", idx, stepLabel(step))
+		start := time.Now()
+		before := make(map[string]string, len(ctx.Variables))
+		for name, value := range ctx.Variables {
+			before[name] = value
+		}
+		ctx.DriverLock.Lock()
+		err := executeStep(ctx, step)
+		ctx.DriverLock.Unlock()
+		if errors.Is(err, errStepSkipped) {
+			skipped++
+			results.Record(newSkippedResult(idx, step))
+			continue
+		}
+		// Steps with their own 'retries' have been retried by executeStep already
+		for attempt := 1; tolerateActions && err != nil && !isAssertion(err) && !isValidation(err) && step.Retries == 0 && attempt <= *actionRetriesFlag; attempt++ {
+			log.Printf("Step %d (%s) failed: %v, retrying (attempt %d of %d)", idx, stepLabel(step), err, attempt, *actionRetriesFlag)
+			ctx.DriverLock.Lock()
+			err = executeStep(ctx, step)
+			ctx.DriverLock.Unlock()
+		}
+		result := newStepResult(idx, step, err, time.Since(start))
+		result.Variables = changedVariables(before, ctx.Variables)
+		results.Record(result)
+		if *screenshotEachStepFlag != "" {
+			filename := filepath.Join(*screenshotEachStepFlag, fmt.Sprintf("%03d_%s.png", idx, step.Action))
+			ctx.DriverLock.Lock()
+			serr := saveScreenshot(ctx, filename)
+			ctx.DriverLock.Unlock()
+			if serr != nil {
+				log.Printf("Failed to capture screenshot for step %d: %v", idx, serr)
+			}
+		}
+		if consolePattern != nil {
+			ctx.DriverLock.Lock()
+			matches, cerr := consoleMatches(ctx, consolePattern)
+			ctx.DriverLock.Unlock()
+			consoleFailures = append(consoleFailures, matches...)
+			if cerr != nil {
+				failures = append(failures, fmt.Errorf("-fail-on-console could not read the console log after step %d: %v", idx, cerr))
+				if !*continueOnErrorFlag {
+					break
+				}
+				consolePattern = nil
+			}
+		}
+		if err != nil && tolerateActions && !isAssertion(err) && !isValidation(err) {
+			log.Printf("Continuing after error in step %d (%s) at %s: %v", idx, stepLabel(step), stepLocation(step), err)
+			tolerated++
+			continue
+		}
+		if err != nil {
+			failed++
+			failures = append(failures, fmt.Errorf("error executing step %d (%s) at %s: %w", idx, stepLabel(step), stepLocation(step), err))
+			if !*continueOnErrorFlag {
+				break
+			}
+			log.Printf("Continuing after error in step %d (%s): %v", idx, stepLabel(step), err)
+		}
+	}
+
+	dumpVariables(ctx, *dumpVarsFlag)
+	results.Flush()
+	if *continueOnErrorFlag {
+		passed := len(jsonData) - skipped - tolerated - failed
+		if tolerateActions {
+			// Tolerated steps don't fail the run, so they're counted apart from the failures
+			fmt.Fprintf(console, "Summary: %d passed, %d failed, %d tolerated, %d skipped\n", passed, failed, tolerated, skipped)
+		} else {
+			fmt.Fprintf(console, "Summary: %d passed, %d failed, %d skipped\n", passed, failed, skipped)
+		}
+	}
+	if len(failures) > 0 {
+		for _, failure := range failures {
+			log.Print(failure)
+		}
+		if *pauseOnErrorFlag {
+			waitForEnter()
 		}
+		return 1
+	}
+	if len(consoleFailures) > 0 {
+		log.Printf("%d console messages matched -fail-on-console:\n  %s", len(consoleFailures), strings.Join(consoleFailures, "\n  "))
+		return 1
+	}
+	if tolerated > 0 {
+		fmt.Fprintf(console, "All assertions passed, %d steps failed with operational errors.\n", tolerated)
+		return 0
 	}
+	if skipped > 0 {
+		fmt.Fprintf(console, "All selected steps executed successfully (%d executed, %d skipped).\n", len(jsonData)-skipped, skipped)
+		return 0
+	}
+	fmt.Fprintln(console, "All steps executed successfully.")
+	return 0
+}
 
-	fmt.Println("All steps executed successfully.")
+// waitForEnter blocks until the user presses Enter. It reads from the terminal rather than stdin,
+// which may have been used for the steps.
+func waitForEnter() {
+	input := os.Stdin
+	if tty, err := os.Open("/dev/tty"); err == nil {
+		defer tty.Close()
+		input = tty
+	}
+	fmt.Fprintln(os.Stderr, "Paused, the browser is left as it was. Press Enter to clean up and exit.")
+	bufio.NewReader(input).ReadString('\n')
 }
 
 // readJSONFromStdin reads all data from stdin and unmarshals it into JSONData
@@ -125,116 +664,930 @@ func readJSONFromStdin() (JSONData, error) {
 			return nil, fmt.Errorf("error reading stdin: %v", err)
 		}
 	}
+	return parseSteps("<stdin>", []byte(sb.String()))
+}
+
+// readJSONFromFiles reads each file and concatenates their steps in order
+func readJSONFromFiles(paths []string) (JSONData, error) {
 	var jsonData JSONData
-	if err := json.Unmarshal([]byte(sb.String()), &jsonData); err != nil {
-		return nil, fmt.Errorf("error parsing JSON: %v", err)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+		steps, err := parseSteps(path, data)
+		if err != nil {
+			return nil, err
+		}
+		jsonData = append(jsonData, steps...)
 	}
 	return jsonData, nil
 }
 
-// initializeWebDriver sets up the Selenium WebDriver based on the provided flags
-func initializeWebDriver(browser, webdriverPath string, headless bool, width, height, timeout, port int) (selenium.WebDriver, *selenium.Service, error) {
-	var service *selenium.Service
-	var err error
-	var caps selenium.Capabilities
-	selenium.SetDebug(true)
-	// Define browser-specific capabilities
-	switch browser {
-	case "firefox":
-		caps = selenium.Capabilities{"browserName": "firefox"}
-		firefoxCaps := firefox.Capabilities{
-			Args: []string{},
-		}
-		if headless {
-			firefoxCaps.Args = append(firefoxCaps.Args, "-headless")
-		}
-		caps.AddFirefox(firefoxCaps)
-	case "chrome":
-		caps = selenium.Capabilities{"browserName": "chrome"}
-		chromeCaps := chrome.Capabilities{
-			Args: []string{},
+// readJSONFromURL fetches the steps from an HTTP(S) endpoint
+func readJSONFromURL(url string, timeout time.Duration) (JSONData, error) {
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %v", url, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching %s: %s", url, response.Status)
+	}
+	if contentType := response.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid content type '%s' from %s: %v", contentType, url, err)
 		}
-		if headless {
-			chromeCaps.Args = append(chromeCaps.Args, "--headless")
+		if mediaType != "application/json" && mediaType != "text/plain" && !strings.HasSuffix(mediaType, "+json") {
+			return nil, fmt.Errorf("unexpected content type '%s' from %s, expected JSON", mediaType, url)
 		}
-		caps.AddChrome(chromeCaps)
-	default:
-		return nil, nil, fmt.Errorf("unsupported browser: %s", browser)
 	}
-
-	// Start a WebDriver server instance
-	service, err = startWebDriverService(browser, webdriverPath, port)
+	data, err := io.ReadAll(response.Body)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to start WebDriver service: %v", err)
+		return nil, fmt.Errorf("error reading %s: %v", url, err)
 	}
+	return parseSteps(url, data)
+}
 
-	// Connect to the WebDriver instance running locally.
-	wd, err := selenium.NewRemote(selenium.Capabilities{"alwaysMatch": caps}, fmt.Sprintf("http://127.0.0.1:%d", port))
+// precheckURL does a plain HTTP GET of url so an unreachable target is reported before a browser
+// is launched. Any response below 500 counts as reachable.
+func precheckURL(url string, timeout time.Duration) error {
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Get(url)
 	if err != nil {
-		return nil, nil, First[error](
-			service.Stop(),
-			fmt.Errorf("failed to resize window: %v", err),
-		)
+		return fmt.Errorf("error fetching %s: %v", url, err)
 	}
-	// Set window size
-	if err = wd.ResizeWindow("", width, height); err != nil {
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("error fetching %s: %s", url, response.Status)
+	}
+	return nil
+}
 
-		return nil, nil, First[error](
-			wd.Quit(),
-			service.Stop(),
-			fmt.Errorf("failed to resize window: %v", err),
-		)
+// resolveURL resolves a relative step URL against ctx.BaseURL. Absolute URLs, and all URLs when
+// no base URL is set, are returned unchanged.
+func resolveURL(ctx *Context, raw string) (string, error) {
+	if ctx.BaseURL == nil {
+		return raw, nil
+	}
+	ref, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid url '%s': %v", raw, err)
 	}
+	return ctx.BaseURL.ResolveReference(ref).String(), nil
+}
 
-	// Set implicit wait timeout
-	if err = wd.SetImplicitWaitTimeout(time.Duration(timeout) * time.Second); err != nil {
+// parseSteps decodes a JSON array of steps read from name, recording the line each step starts on
+func parseSteps(name string, data []byte) (JSONData, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := decoder.Token(); err != nil || tok != json.Delim('[') {
+		var jsonData JSONData
+		if err := json.Unmarshal(data, &jsonData); err != nil {
+			return nil, parseError(name, data, err)
+		}
+		return nil, fmt.Errorf("error parsing JSON in %s: expected an array of steps", name)
+	}
+	var jsonData JSONData
+	for decoder.More() {
+		offset := int(decoder.InputOffset())
+		for offset < len(data) && strings.ContainsRune(" \t\r\n,", rune(data[offset])) {
+			offset++
+		}
+		var step Step
+		if err := decoder.Decode(&step); err != nil {
+			return nil, parseError(name, data, err)
+		}
+		step.Source = fmt.Sprintf("%s:%d", name, bytes.Count(data[:offset], []byte("\n"))+1)
+		step.Path = fmt.Sprintf("step[%d]", len(jsonData))
+		if step.Action == "" {
+			return nil, fmt.Errorf("%s has no 'action'", stepLocation(step))
+		}
+		jsonData = append(jsonData, step)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, parseError(name, data, err)
+	}
+	return jsonData, nil
+}
 
-		return nil, nil, First[error](
-			wd.Quit(),
-			service.Stop(),
-			fmt.Errorf("failed to resize window: %v", err),
-		)
+// formatSteps encodes steps in canonical form: fields in Step declaration order, params keys
+// sorted, two-space indentation and no HTML escaping. extra holds keys of each step that aren't
+// Step fields, which are kept after the known ones in sorted order.
+func formatSteps(steps JSONData, extra []map[string]json.RawMessage) ([]byte, error) {
+	merged := make([]json.RawMessage, len(steps))
+	for i, step := range steps {
+		known, err := encodeJSON(step, "")
+		if err != nil {
+			return nil, err
+		}
+		var keys []string
+		for key := range extra[i] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf := bytes.NewBuffer(bytes.TrimSuffix(bytes.TrimSpace(known), []byte("}")))
+		for _, key := range keys {
+			name, err := encodeJSON(key, "")
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteByte(',')
+			buf.Write(bytes.TrimSpace(name))
+			buf.WriteByte(':')
+			buf.Write(extra[i][key])
+		}
+		buf.WriteByte('}')
+		merged[i] = buf.Bytes()
 	}
+	return encodeJSON(merged, "  ")
+}
 
-	return wd, service, nil
+// encodeJSON encodes v without HTML escaping, indented by indent unless it is empty
+func encodeJSON(v interface{}, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if indent != "" {
+		encoder.SetIndent("", indent)
+	}
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
-// startWebDriverService starts the appropriate WebDriver service based on the browser
-func startWebDriverService(browser, webdriverPath string, port int) (*selenium.Service, error) {
-	var service *selenium.Service
-	var err error
+// formatFiles formats each path separately, or stdin when paths is empty. The result is written
+// back to the file when write is set and printed to stdout otherwise.
+func formatFiles(paths []string, write bool) error {
+	if len(paths) == 0 {
+		if write {
+			return errors.New("-w requires step files, given with -file or as arguments")
+		}
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("error reading stdin: %v", err)
+		}
+		formatted, err := formatData("<stdin>", data)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(formatted)
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", path, err)
+		}
+		formatted, err := formatData(path, data)
+		if err != nil {
+			return err
+		}
+		if write {
+			if err := os.WriteFile(path, formatted, 0644); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := os.Stdout.Write(formatted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	switch browser {
-	case "firefox":
-		if webdriverPath == "" {
-			// Assume geckodriver is in PATH
-			webdriverPath = "geckodriver"
+// formatData formats the steps in data. Keys that aren't step fields, like the "timestamp" the
+// recorder extension adds, are kept so formatting never loses anything.
+func formatData(name string, data []byte) ([]byte, error) {
+	steps, err := parseSteps(name, data)
+	if err != nil {
+		return nil, err
+	}
+	var raw []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, parseError(name, data, err)
+	}
+	fields := stepFields()
+	extra := make([]map[string]json.RawMessage, len(raw))
+	for i, step := range raw {
+		for key, value := range step {
+			if fields[key] {
+				continue
+			}
+			if extra[i] == nil {
+				extra[i] = map[string]json.RawMessage{}
+			}
+			extra[i][key] = value
 		}
+	}
+	return formatSteps(steps, extra)
+}
 
-		service, err = selenium.NewGeckoDriverService(webdriverPath, port, selenium.Output(os.Stderr))
-	case "chrome":
-		if webdriverPath == "" {
-			// Assume chromedriver is in PATH
-			webdriverPath = "chromedriver"
+// stepFields returns the JSON names of the Step fields
+func stepFields() map[string]bool {
+	fields := map[string]bool{}
+	stepType := reflect.TypeOf(Step{})
+	for i := 0; i < stepType.NumField(); i++ {
+		name := strings.Split(stepType.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = true
 		}
-		service, err = selenium.NewChromeDriverService(webdriverPath, port, selenium.Output(os.Stderr))
+	}
+	return fields
+}
 
-	default:
-		return nil, fmt.Errorf("unsupported browser: %s", browser)
+// parseError annotates a JSON decoding error with the line and column it occurred at in data
+func parseError(name string, data []byte, err error) error {
+	var offset int64 = -1
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	// The decoder's offsets count the byte it failed on, point at that byte rather than past it
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset - 1
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset - 1
+	case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		offset = int64(len(data))
 	}
+	if offset < 0 || offset > int64(len(data)) {
+		return fmt.Errorf("error parsing JSON in %s: %v", name, err)
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := int(offset) - bytes.LastIndexByte(before, '\n')
+	return fmt.Errorf("error parsing JSON in %s:%d:%d: %v", name, line, col, err)
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to start WebDriver service for %s: %v", browser, err)
+// stringList is a flag.Value collecting repeated or comma-separated values
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*l = append(*l, part)
+		}
 	}
+	return nil
+}
 
-	return service, nil
+// DriverOptions collects the flags that control how the browser and WebDriver service are started
+type DriverOptions struct {
+	Browser       string
+	WebDriverPath string
+	Headless      bool
+	Width         int
+	Height        int
+	Timeout       int
+	Port          int
+	// AutoNoSandbox adds --no-sandbox to Chrome when running as root inside a container
+	AutoNoSandbox bool
+	// StartTimeout bounds how long to retry the session request until the WebDriver server is
+	// ready. It doesn't cover the startup check selenium.NewChromeDriverService and friends run.
+	StartTimeout time.Duration
+	// BrowserBinary is the browser executable to launch, the driver's default when empty
+	BrowserBinary string
+	// ProfileDir is a browser profile directory to use, and keep changes in, instead of a fresh one
+	ProfileDir string
+	// Extensions are .crx/.xpi files, or unpacked extension directories for Chrome, to load
+	Extensions []string
+	// Proxy routes the browser's traffic through a proxy when set
+	Proxy *selenium.Proxy
+	// ExtraCaps are capabilities merged over the ones derived from the other options
+	ExtraCaps map[string]interface{}
+	// RemoteURL is the URL of a running WebDriver server or Selenium Grid to use instead of
+	// starting a local service, which makes Port and WebDriverPath irrelevant
+	RemoteURL string
+	// NetworkEvents enables the Chrome/Edge performance log the network assertions read
+	NetworkEvents bool
+	// ConsoleLog enables the Chrome/Edge browser log -fail-on-console reads
+	ConsoleLog bool
 }
 
-// executeStep performs the action defined in a single step
-func executeStep(ctx *Context, step Step) error {
-	fmt.Printf("Executing action: %s\n", step.Action)
-	switch step.Action {
-	case "navigate":
+// buildCapabilities returns the capabilities requesting a session for opts.Browser configured by opts
+func buildCapabilities(opts DriverOptions) (selenium.Capabilities, error) {
+	var caps selenium.Capabilities
+	var err error
+
+	rootInContainer := os.Geteuid() == 0 && runningInContainer()
+	if rootInContainer {
+		log.Printf("Warning: running as root inside a container; browsers may refuse to start without --no-sandbox")
+	}
+
+	// Define browser-specific capabilities
+	switch opts.Browser {
+	case "firefox":
+		caps = selenium.Capabilities{"browserName": "firefox"}
+		firefoxCaps := firefox.Capabilities{
+			Args:   []string{},
+			Binary: opts.BrowserBinary,
+		}
+		if opts.Headless {
+			firefoxCaps.Args = append(firefoxCaps.Args, "-headless")
+		}
+		if opts.ProfileDir != "" {
+			// Passed as an argument rather than a zipped copy so the profile keeps its changes
+			firefoxCaps.Args = append(firefoxCaps.Args, "-profile", opts.ProfileDir)
+		}
+		caps.AddFirefox(firefoxCaps)
+	case "chrome":
+		caps = selenium.Capabilities{"browserName": "chrome"}
+		chromeCaps := chrome.Capabilities{
+			Args: []string{},
+			Path: opts.BrowserBinary,
+		}
+		if opts.Headless && len(opts.Extensions) > 0 {
+			// The old headless mode doesn't load extensions
+			chromeCaps.Args = append(chromeCaps.Args, "--headless=new")
+		} else if opts.Headless {
+			chromeCaps.Args = append(chromeCaps.Args, "--headless")
+		}
+		if err := addChromiumProfile(&chromeCaps, opts); err != nil {
+			return nil, err
+		}
+		if rootInContainer && opts.AutoNoSandbox {
+			log.Printf("Adding --no-sandbox to Chrome (disable with -auto-no-sandbox=false)")
+			chromeCaps.Args = append(chromeCaps.Args, "--no-sandbox")
+		}
+		caps.AddChrome(chromeCaps)
+		if logging := chromiumLogging(opts); len(logging) > 0 {
+			caps.AddLogging(logging)
+		}
+	case "edge":
+		caps = selenium.Capabilities{"browserName": "MicrosoftEdge"}
+		// Edge is Chromium based and takes the same options as Chrome under its own key
+		edgeCaps := chrome.Capabilities{
+			Args: []string{},
+			Path: opts.BrowserBinary,
+		}
+		if opts.Headless {
+			edgeCaps.Args = append(edgeCaps.Args, "--headless=new")
+		}
+		if err := addChromiumProfile(&edgeCaps, opts); err != nil {
+			return nil, err
+		}
+		if rootInContainer && opts.AutoNoSandbox {
+			log.Printf("Adding --no-sandbox to Edge (disable with -auto-no-sandbox=false)")
+			edgeCaps.Args = append(edgeCaps.Args, "--no-sandbox")
+		}
+		caps["ms:edgeOptions"] = edgeCaps
+		if logging := chromiumLogging(opts); len(logging) > 0 {
+			caps["ms:loggingPrefs"] = logging
+		}
+	default:
+		return nil, fmt.Errorf("unsupported browser: %s", opts.Browser)
+	}
+
+	if opts.Proxy != nil {
+		caps.AddProxy(*opts.Proxy)
+	}
+	if opts.ExtraCaps != nil {
+		if caps, err = mergeCapabilities(caps, opts.ExtraCaps); err != nil {
+			return nil, err
+		}
+	}
+	return caps, nil
+}
+
+// chromiumLogging returns the logs to enable in Chrome or Edge. Performance logs carry the DevTools
+// network events used by the network assertions, browser logs the console messages checked by
+// -fail-on-console. Both are only enabled when needed, the performance log being large.
+func chromiumLogging(opts DriverOptions) seleniumlog.Capabilities {
+	logging := seleniumlog.Capabilities{}
+	if opts.NetworkEvents {
+		logging[seleniumlog.Performance] = seleniumlog.All
+	}
+	if opts.ConsoleLog {
+		logging[seleniumlog.Browser] = seleniumlog.All
+	}
+	return logging
+}
+
+// initializeWebDriver sets up the Selenium WebDriver based on the provided flags
+func initializeWebDriver(opts DriverOptions) (selenium.WebDriver, *selenium.Service, error) {
+	var service *selenium.Service
+	browser, port := opts.Browser, opts.Port
+	selenium.SetDebug(true)
+
+	caps, err := buildCapabilities(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	urlPrefix := opts.RemoteURL
+	if urlPrefix == "" {
+		// Start a WebDriver server instance
+		service, err = startWebDriverService(browser, opts.WebDriverPath, port)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to start WebDriver service: %v", err)
+		}
+		// Connect to the WebDriver instance running locally.
+		urlPrefix = fmt.Sprintf("http://127.0.0.1:%d", port)
+	}
+	wd, err := connectWebDriver(caps, urlPrefix, opts.StartTimeout)
+	if err != nil {
+		stopService(service)
+		return nil, nil, fmt.Errorf("failed to start WebDriver session: %v", err)
+	}
+	if browser == "firefox" {
+		if err = installFirefoxExtensions(&Context{WebDriver: wd, URL: urlPrefix}, opts.Extensions); err != nil {
+			wd.Quit()
+			stopService(service)
+			return nil, nil, err
+		}
+	}
+
+	// Set window size
+	if err = wd.ResizeWindow("", opts.Width, opts.Height); err != nil {
+		wd.Quit()
+		stopService(service)
+		return nil, nil, fmt.Errorf("failed to resize window: %v", err)
+	}
+
+	// Set implicit wait timeout
+	if err = wd.SetImplicitWaitTimeout(time.Duration(opts.Timeout) * time.Second); err != nil {
+		wd.Quit()
+		stopService(service)
+		return nil, nil, fmt.Errorf("failed to set implicit wait timeout: %v", err)
+	}
+
+	return wd, service, nil
+}
+
+// parseProxy builds the proxy capability for proxyURL, inferring the type from its scheme:
+// http:// and https:// proxies handle both HTTP and HTTPS traffic, socks4:// and socks5:// (or
+// socks://) are SOCKS proxies and URLs with a pac+ scheme prefix or ending in .pac are PAC files.
+// Hosts in bypass are connected to directly. Only SOCKS proxies take credentials from the URL, and
+// PAC files decide on bypassing themselves.
+func parseProxy(proxyURL string, bypass []string) (*selenium.Proxy, error) {
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url '%s': %v", proxyURL, err)
+	}
+	if strings.HasPrefix(parsed.Scheme, "pac+") || strings.HasSuffix(parsed.Path, ".pac") {
+		if len(bypass) > 0 {
+			return nil, errors.New("-proxy-bypass doesn't apply to a PAC proxy, return DIRECT for those hosts from the PAC file instead")
+		}
+		return &selenium.Proxy{Type: selenium.PAC, AutoconfigURL: strings.TrimPrefix(proxyURL, "pac+")}, nil
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("proxy url '%s' has no host", proxyURL)
+	}
+	proxy := &selenium.Proxy{Type: selenium.Manual, NoProxy: bypass}
+	switch parsed.Scheme {
+	case "http", "https":
+		if parsed.User != nil {
+			// The proxy capability has no credentials for HTTP proxies, they would be dropped silently
+			return nil, fmt.Errorf("proxy url '%s' has credentials, which are only supported for SOCKS proxies", parsed.Redacted())
+		}
+		proxy.HTTP = parsed.Host
+		proxy.SSL = parsed.Host
+	case "socks", "socks5", "socks4":
+		proxy.SOCKS = parsed.Host
+		proxy.SOCKSVersion = 5
+		if parsed.Scheme == "socks4" {
+			proxy.SOCKSVersion = 4
+		}
+		if parsed.User != nil {
+			proxy.SOCKSUsername = parsed.User.Username()
+			proxy.SOCKSPassword, _ = parsed.User.Password()
+		}
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme '%s', expected http, https, socks4, socks5 or a PAC url", parsed.Scheme)
+	}
+	return proxy, nil
+}
+
+// loadCapabilities reads a JSON object of W3C capabilities from filename
+func loadCapabilities(filename string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", filename, err)
+	}
+	var caps map[string]interface{}
+	if err := json.Unmarshal(data, &caps); err != nil {
+		return nil, fmt.Errorf("error parsing %s, expected a JSON object: %v", filename, err)
+	}
+	return caps, nil
+}
+
+// mergeCapabilities merges extra over caps, recursing into objects present in both, so e.g. extra
+// arguments in goog:chromeOptions don't drop the flag-derived ones. Each value extra overrides is logged.
+func mergeCapabilities(caps selenium.Capabilities, extra map[string]interface{}) (selenium.Capabilities, error) {
+	// Round-trip through JSON to turn the typed browser options into plain maps
+	data, err := json.Marshal(caps)
+	if err != nil {
+		return nil, err
+	}
+	var merged map[string]interface{}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	mergeObjects(merged, extra, "")
+	return selenium.Capabilities(merged), nil
+}
+
+// mergeObjects merges src into dst, logging overridden values under their dotted path from prefix
+func mergeObjects(dst, src map[string]interface{}, prefix string) {
+	for key, value := range src {
+		old, exists := dst[key]
+		oldObject, oldIsObject := old.(map[string]interface{})
+		newObject, newIsObject := value.(map[string]interface{})
+		switch {
+		case oldIsObject && newIsObject:
+			mergeObjects(oldObject, newObject, prefix+key+".")
+			continue
+		case exists && (oldIsObject || newIsObject):
+			log.Printf("Warning: -caps-file replaces %s%s of a different type (%v with %v)", prefix, key, old, value)
+		case exists && fmt.Sprint(old) != fmt.Sprint(value):
+			log.Printf("-caps-file overrides %s%s: %v -> %v", prefix, key, old, value)
+		}
+		dst[key] = value
+	}
+}
+
+// addChromiumProfile adds the profile directory and extensions of opts to Chrome or Edge capabilities
+func addChromiumProfile(c *chrome.Capabilities, opts DriverOptions) error {
+	if opts.ProfileDir != "" {
+		c.Args = append(c.Args, "--user-data-dir="+opts.ProfileDir)
+	}
+	for _, extension := range opts.Extensions {
+		info, err := os.Stat(extension)
+		if err != nil {
+			return fmt.Errorf("failed to load extension: %v", err)
+		}
+		if info.IsDir() {
+			err = c.AddUnpackedExtension(extension)
+		} else {
+			err = c.AddExtension(extension)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load extension %s: %v", extension, err)
+		}
+	}
+	return nil
+}
+
+// installFirefoxExtensions installs .xpi extensions into the running Firefox session. geckodriver
+// can't take them as capabilities, and temporary installation allows unsigned extensions. The
+// files are sent rather than their paths, which don't exist on a remote Grid node.
+func installFirefoxExtensions(ctx *Context, extensions []string) error {
+	for _, extension := range extensions {
+		data, err := os.ReadFile(extension)
+		if err != nil {
+			return fmt.Errorf("failed to read extension %s: %v", extension, err)
+		}
+		addon := base64.StdEncoding.EncodeToString(data)
+		if _, err := w3cCommand(ctx, http.MethodPost, "/moz/addon/install", map[string]interface{}{"addon": addon, "temporary": true}); err != nil {
+			return fmt.Errorf("failed to install extension %s: %v", extension, err)
+		}
+	}
+	return nil
+}
+
+// stopService stops service, which is nil when connected to a remote WebDriver
+func stopService(service *selenium.Service) error {
+	if service == nil {
+		return nil
+	}
+	return service.Stop()
+}
+
+// connectWebDriver waits until the WebDriver server at urlPrefix reports ready and opens a session,
+// retrying transient connection failures until timeout elapses
+func connectWebDriver(caps selenium.Capabilities, urlPrefix string, timeout time.Duration) (selenium.WebDriver, error) {
+	endTime := time.Now().Add(timeout)
+	for {
+		err := webDriverReady(urlPrefix)
+		if err == nil {
+			var wd selenium.WebDriver
+			if wd, err = selenium.NewRemote(selenium.Capabilities{"alwaysMatch": caps}, urlPrefix); err == nil {
+				return wd, nil
+			}
+		}
+		if time.Now().After(endTime) {
+			return nil, fmt.Errorf("WebDriver at %s not ready after %v: %v", urlPrefix, timeout, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// webDriverReady queries the /status endpoint of the WebDriver server at urlPrefix
+func webDriverReady(urlPrefix string) error {
+	response, err := selenium.HTTPClient.Get(urlPrefix + "/status")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	var status struct {
+		Value struct {
+			Ready   bool   `json:"ready"`
+			Message string `json:"message"`
+		} `json:"value"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&status); err != nil {
+		return fmt.Errorf("invalid status reply: %v", err)
+	}
+	if !status.Value.Ready {
+		return fmt.Errorf("not ready: %s", status.Value.Message)
+	}
+	return nil
+}
+
+// availablePort returns port if it can be bound on localhost, otherwise a free ephemeral port
+func availablePort(port int) (int, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		if listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			return 0, err
+		}
+		log.Printf("Port %d is busy, using port %d instead", port, listener.Addr().(*net.TCPAddr).Port)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// runningInContainer reports whether the process appears to run inside a Docker, Podman or
+// Kubernetes container
+func runningInContainer() bool {
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	cgroup, err := os.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+	for _, hint := range []string{"docker", "kubepods", "containerd", "libpod"} {
+		if strings.Contains(string(cgroup), hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// startWebDriverService starts the appropriate WebDriver service based on the browser
+func startWebDriverService(browser, webdriverPath string, port int) (*selenium.Service, error) {
+	var service *selenium.Service
+	var err error
+
+	switch browser {
+	case "firefox":
+		if webdriverPath == "" {
+			// Assume geckodriver is in PATH
+			webdriverPath = "geckodriver"
+		}
+
+		service, err = selenium.NewGeckoDriverService(webdriverPath, port, selenium.Output(os.Stderr))
+	case "chrome":
+		if webdriverPath == "" {
+			// Assume chromedriver is in PATH
+			webdriverPath = "chromedriver"
+		}
+		service, err = selenium.NewChromeDriverService(webdriverPath, port, selenium.Output(os.Stderr))
+	case "edge":
+		if webdriverPath == "" {
+			// Assume msedgedriver is in PATH
+			webdriverPath = "msedgedriver"
+		}
+		// msedgedriver is a chromedriver build and accepts the same command line
+		service, err = selenium.NewChromeDriverService(webdriverPath, port, selenium.Output(os.Stderr))
+
+	default:
+		return nil, fmt.Errorf("unsupported browser: %s", browser)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to start WebDriver service for %s: %v", browser, err)
+	}
+
+	return service, nil
+}
+
+// AssertionError is a failed check of the application under test, as opposed to an operational
+// error such as an element that could not be found or clicked
+type AssertionError struct {
+	Err error
+}
+
+func (e *AssertionError) Error() string { return e.Err.Error() }
+
+func (e *AssertionError) Unwrap() error { return e.Err }
+
+// isAssertion reports whether err comes from an assertion step
+func isAssertion(err error) bool {
+	var assertion *AssertionError
+	return errors.As(err, &assertion)
+}
+
+// ValidationError is a malformed step, like a click without a selector or a timeout that isn't a
+// number. Retrying such a step is pointless and tolerating it would hide the mistake.
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string { return e.Err.Error() }
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// validationErrorf formats a ValidationError
+func validationErrorf(format string, args ...interface{}) error {
+	return &ValidationError{Err: fmt.Errorf(format, args...)}
+}
+
+// isValidation reports whether err comes from a malformed step
+func isValidation(err error) bool {
+	var validation *ValidationError
+	return errors.As(err, &validation)
+}
+
+// evaluateCondition checks condition against ctx.Variables, expanding placeholders in its value
+func evaluateCondition(ctx *Context, condition Condition) (bool, error) {
+	if condition.Var == "" {
+		return false, validationErrorf("condition requires 'var'")
+	}
+	actual, exists := ctx.Variables[condition.Var]
+	expected := interpolate(ctx, condition.Value)
+	switch condition.Op {
+	case "equals":
+		return exists && actual == expected, nil
+	case "not_equals":
+		return !exists || actual != expected, nil
+	case "contains":
+		return exists && strings.Contains(actual, expected), nil
+	case "exists":
+		return exists, nil
+	default:
+		return false, validationErrorf("condition 'op' should be one of equals, not_equals, contains or exists, got '%s'", condition.Op)
+	}
+}
+
+// errStepSkipped is returned by executeStep for a step whose condition doesn't hold
+var errStepSkipped = errors.New("step skipped")
+
+// executeStep performs step, retrying it up to step.Retries times, step.RetryDelay seconds apart,
+// unless it failed because the step itself is invalid. It returns errStepSkipped without doing
+// anything if the step's condition is false.
+func executeStep(ctx *Context, step Step) error {
+	if step.Condition != nil {
+		holds, err := evaluateCondition(ctx, *step.Condition)
+		if err != nil {
+			return err
+		}
+		if !holds {
+			fmt.Fprintf(console, "Skipping %s: condition %s %s '%s' is false\n", stepLabel(step), step.Condition.Var, step.Condition.Op, step.Condition.Value)
+			return errStepSkipped
+		}
+	}
+	err := executeStepOnce(ctx, step)
+	for attempt := 1; err != nil && attempt <= step.Retries && !isValidation(err); attempt++ {
+		delay := time.Duration(step.RetryDelay * float64(time.Second))
+		log.Printf("Step %s (%s) failed: %v, retrying in %v (%d attempts left)", stepLocation(step), stepLabel(step), err, delay, step.Retries-attempt+1)
+		if serr := sleepWithKeepAlive(ctx, delay); serr != nil {
+			return serr
+		}
+		err = executeStepOnce(ctx, step)
+	}
+	return err
+}
+
+// executeStepOnce performs step, marking errors of assert_ actions as AssertionErrors. With
+// 'params.frame' the step runs inside that frame and the previous frame is restored afterwards.
+func executeStepOnce(ctx *Context, step Step) (err error) {
+	if frame, ok := step.Params["frame"]; ok && step.Action != "switch_to_frame" {
+		if err := enterFrame(ctx, frame); err != nil {
+			return fmt.Errorf("failed to switch to frame %v: %v", frame, err)
+		}
+		defer func() {
+			if _, perr := w3cCommand(ctx, http.MethodPost, "/frame/parent", map[string]interface{}{}); perr != nil && err == nil {
+				err = fmt.Errorf("failed to switch back from frame %v: %v", frame, perr)
+			}
+		}()
+	}
+	err = performAction(ctx, step)
+	if err != nil && strings.HasPrefix(step.Action, "assert_") && !isAssertion(err) {
+		return &AssertionError{Err: err}
+	}
+	return err
+}
+
+// knownActions are the actions performAction dispatches, for checking steps without running them
+var knownActions = map[string]bool{
+	"navigate": true, "click": true, "double_click": true, "right_click": true, "context_click": true,
+	"tap": true, "for_each": true, "retry_until": true, "enter_text": true, "clear": true,
+	"select_option": true, "deselect_option": true, "get_selected_option": true, "get_text": true,
+	"get_attribute": true, "get_element_count": true, "get_all_text": true, "snapshot_element": true,
+	"get_property": true, "wait": true, "wait_for_stable": true, "wait_for_visible": true,
+	"wait_for_clickable": true, "wait_for_removed": true, "wait_for_jquery": true,
+	"wait_for_title": true, "wait_for_any": true, "wait_for_all": true, "screenshot": true,
+	"screenshot_element": true, "take_element_screenshot": true, "execute_script": true,
+	"execute_async_script": true, "scroll": true, "scroll_to_element": true,
+	"scroll_to_percent": true, "hover": true, "drag_and_drop": true, "switch_to_frame": true,
+	"switch_to_default_content": true, "close_browser": true, "quit_browser": true,
+	"switch_to_window": true, "list_windows": true, "close_window": true, "open_new_window": true,
+	"maximize_window": true, "fullscreen_window": true, "set_window_size": true,
+	"set_window_position": true, "get_window_count": true, "assert_window_count": true,
+	"wait_for_alert": true, "accept_alert": true, "dismiss_alert": true, "get_alert_text": true,
+	"send_alert_text": true, "answer_prompt": true, "assert_title": true, "get_url": true,
+	"assert_url": true, "assert_text": true, "assert_element_text": true,
+	"assert_element_present": true, "assert_redirect_chain": true, "assert_no_failed_requests": true,
+	"assert_table": true, "assert_order": true, "assert_contrast": true, "assert_in_viewport": true,
+	"press_keys": true, "press_key": true, "paste": true, "set_attribute": true,
+	"remove_attribute": true, "assert_element_count": true, "assert_no_broken_images": true,
+	"assert_contains_element": true, "assert_attribute": true, "assert_attribute_matches": true,
+	"print": true, "set_cookie": true, "add_cookie": true, "get_cookie": true, "delete_cookie": true,
+	"delete_all_cookies": true, "inject_on_new_document": true, "set_permission": true,
+	"get_clipboard": true, "get_accessibility_node": true,
+}
+
+// checkActions reports the steps, nested ones included, whose action performAction doesn't know
+func checkActions(steps []Step) []error {
+	var problems []error
+	for _, step := range steps {
+		if !knownActions[step.Action] {
+			problems = append(problems, fmt.Errorf("%s: unknown action '%s'", stepLocation(step), step.Action))
+		}
+		// The keys of nestedStepParams, in a fixed order for stable output
+		for _, key := range []string{"steps", "until", "then"} {
+			value, ok := step.Params[key]
+			if !ok {
+				continue
+			}
+			if key == "until" {
+				value = []interface{}{value}
+			}
+			nested, err := nestedSteps(value)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s: invalid 'params.%s': %v", stepLocation(step), key, err))
+				continue
+			}
+			for i := range nested {
+				nested[i].Source = step.Source
+				nested[i].Path = fmt.Sprintf("%s.%s[%d]", step.Path, key, i)
+			}
+			problems = append(problems, checkActions(nested)...)
+		}
+	}
+	return problems
+}
+
+// networkActions are the actions reading the performance log
+var networkActions = map[string]bool{"assert_redirect_chain": true, "assert_no_failed_requests": true}
+
+// usesActions reports whether steps, or the steps nested in them, include one of actions
+func usesActions(steps []Step, actions map[string]bool) bool {
+	for _, step := range steps {
+		if actions[step.Action] {
+			return true
+		}
+		for key := range nestedStepParams {
+			value, ok := step.Params[key]
+			if !ok {
+				continue
+			}
+			if key == "until" {
+				value = []interface{}{value}
+			}
+			if nested, err := nestedSteps(value); err == nil && usesActions(nested, actions) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// performAction performs the action defined in a single step, after expanding {{name}}
+// placeholders in its string fields and params. Steps without a timeout get ctx.DefaultTimeout.
+func performAction(ctx *Context, step Step) error {
+	fmt.Fprintf(console, "Executing action: %s\n", step.Action)
+	if step.Timeout == 0 {
+		step.Timeout = ctx.DefaultTimeout
+	}
+	for _, field := range []*string{
+		&step.URL, &step.Text, &step.Selector, &step.ExpectedValue, &step.Script,
+		&step.Filename, &step.Message, &step.Value, &step.ElementSelector,
+	} {
+		*field = interpolate(ctx, *field)
+	}
+	if step.Params != nil {
+		step.Params = interpolateParams(ctx, step.Params)
+	}
+	if step.URL != "" {
+		resolved, err := resolveURL(ctx, step.URL)
+		if err != nil {
+			return err
+		}
+		step.URL = resolved
+	}
+	switch step.Action {
+	case "navigate":
 		return navigate(ctx, step)
 	case "click":
 		return click(ctx, step)
@@ -242,6 +1595,14 @@ func executeStep(ctx *Context, step Step) error {
 		return doubleClick(ctx, step)
 	case "right_click":
 		return rightClick(ctx, step)
+	case "context_click":
+		return contextClick(ctx, step)
+	case "tap":
+		return tap(ctx, step)
+	case "for_each":
+		return forEach(ctx, step)
+	case "retry_until":
+		return retryUntil(ctx, step)
 	case "enter_text":
 		return enterText(ctx, step)
 	case "clear":
@@ -250,18 +1611,52 @@ func executeStep(ctx *Context, step Step) error {
 		return selectOption(ctx, step)
 	case "deselect_option":
 		return deselectOption(ctx, step)
+	case "get_selected_option":
+		return getSelectedOption(ctx, step)
 	case "get_text":
 		return getText(ctx, step)
 	case "get_attribute":
 		return getAttribute(ctx, step)
+	case "get_element_count":
+		return getElementCount(ctx, step)
+	case "get_all_text":
+		return getAllText(ctx, step)
+	case "snapshot_element":
+		return snapshotElement(ctx, step)
+	case "get_property":
+		return getProperty(ctx, step)
 	case "wait":
-		return waitDuration(step)
+		return waitDuration(ctx, step)
+	case "wait_for_stable":
+		return waitForStable(ctx, step)
+	case "wait_for_visible":
+		return waitForElementState(ctx, step, false)
+	case "wait_for_clickable":
+		return waitForElementState(ctx, step, true)
+	case "wait_for_removed":
+		return waitForRemoved(ctx, step)
+	case "wait_for_jquery":
+		return waitForJQuery(ctx, step)
+	case "wait_for_title":
+		return waitForTitle(ctx, step)
+	case "wait_for_any":
+		return waitForElements(ctx, step, false)
+	case "wait_for_all":
+		return waitForElements(ctx, step, true)
 	case "screenshot":
 		return takeScreenshot(ctx, step)
+	case "screenshot_element", "take_element_screenshot":
+		return takeElementScreenshot(ctx, step)
 	case "execute_script":
 		return executeScript(ctx, step)
+	case "execute_async_script":
+		return executeAsyncScript(ctx, step)
 	case "scroll":
 		return scroll(ctx, step)
+	case "scroll_to_element":
+		return scrollToElement(ctx, step)
+	case "scroll_to_percent":
+		return scrollToPercent(ctx, step)
 	case "hover":
 		return hover(ctx, step)
 	case "drag_and_drop":
@@ -274,418 +1669,4048 @@ func executeStep(ctx *Context, step Step) error {
 		return closeBrowser(ctx)
 	case "quit_browser":
 		return quitBrowser(ctx)
+	case "switch_to_window":
+		return switchToWindow(ctx, step)
+	case "list_windows":
+		return listWindows(ctx, step)
+	case "close_window":
+		return closeWindow(ctx)
+	case "open_new_window":
+		return openNewWindow(ctx, step)
+	case "maximize_window":
+		return ctx.WebDriver.MaximizeWindow("")
+	case "fullscreen_window":
+		_, err := w3cCommand(ctx, http.MethodPost, "/window/fullscreen", map[string]string{})
+		return err
+	case "set_window_size":
+		return setWindowSize(ctx, step)
+	case "set_window_position":
+		return setWindowPosition(ctx, step)
+	case "get_window_count":
+		return getWindowCount(ctx, step)
+	case "assert_window_count":
+		return assertWindowCount(ctx, step)
+	case "wait_for_alert":
+		return waitForAlert(ctx, step)
+	case "accept_alert":
+		return alertError(ctx.WebDriver.AcceptAlert())
+	case "dismiss_alert":
+		return alertError(ctx.WebDriver.DismissAlert())
+	case "get_alert_text":
+		return getAlertText(ctx, step)
+	case "send_alert_text":
+		return alertError(ctx.WebDriver.SetAlertText(step.Text))
+	case "answer_prompt":
+		return answerPrompt(ctx, step)
 	case "assert_title":
 		return assertTitle(ctx, step)
+	case "get_url":
+		return getURL(ctx, step)
+	case "assert_url":
+		return assertURL(ctx, step)
+	case "assert_text", "assert_element_text":
+		return assertText(ctx, step)
 	case "assert_element_present":
 		return assertElementPresent(ctx, step)
+	case "assert_redirect_chain":
+		return assertRedirectChain(ctx, step)
+	case "assert_no_failed_requests":
+		return assertNoFailedRequests(ctx, step)
+	case "assert_table":
+		return assertTable(ctx, step)
+	case "assert_order":
+		return assertOrder(ctx, step)
+	case "assert_contrast":
+		return assertContrast(ctx, step)
+	case "assert_in_viewport":
+		return assertInViewport(ctx, step)
+	case "press_keys", "press_key":
+		return pressKeys(ctx, step)
+	case "paste":
+		return paste(ctx, step)
+	case "set_attribute":
+		return setAttribute(ctx, step, false)
+	case "remove_attribute":
+		return setAttribute(ctx, step, true)
+	case "assert_element_count":
+		return assertElementCount(ctx, step)
+	case "assert_no_broken_images":
+		return assertNoBrokenImages(ctx, step)
+	case "assert_contains_element":
+		return assertContainsElement(ctx, step)
+	case "assert_attribute":
+		return assertAttribute(ctx, step, "equals")
+	case "assert_attribute_matches":
+		return assertAttribute(ctx, step, "regex")
 	case "print":
 		return printMessage(ctx, step)
+	case "set_cookie", "add_cookie":
+		return setCookie(ctx, step)
+	case "get_cookie":
+		return getCookie(ctx, step)
+	case "delete_cookie":
+		return deleteCookie(ctx, step)
+	case "delete_all_cookies":
+		return ctx.WebDriver.DeleteAllCookies()
+	case "inject_on_new_document":
+		return injectOnNewDocument(ctx, step)
+	case "set_permission":
+		return setPermission(ctx, step)
+	case "get_clipboard":
+		return getClipboard(ctx, step)
+	case "get_accessibility_node":
+		return getAccessibilityNode(ctx, step)
 	default:
-		return fmt.Errorf("unknown action: %s", step.Action)
+		return validationErrorf("unknown action: %s", step.Action)
+	}
+}
+
+// Action Handlers
+
+// navigate loads step.URL. With 'params.retries' it re-navigates with exponential backoff starting
+// at 'params.retry_delay' seconds while loading fails or the page looks like an error page: its title
+// contains one of the 'params.retry_on_status' codes or 'params.error_selector' matches.
+// With 'params.ignore_load_errors' a final failure doesn't fail the step; the HTTP status of the
+// loaded document is stored in the navigate_status variable instead ("0" if no response arrived).
+func navigate(ctx *Context, step Step) error {
+	if step.URL == "" {
+		return validationErrorf("navigate action requires 'url'")
+	}
+	retries, delay := 0, time.Second
+	var statuses []string
+	errorSelector := ""
+	ignoreLoadErrors := false
+	if step.Params != nil {
+		if value, ok := step.Params["retries"]; ok {
+			count, ok := value.(float64)
+			if !ok || count < 0 {
+				return validationErrorf("'retries' should be a non-negative number")
+			}
+			retries = int(count)
+		}
+		if value, ok := step.Params["retry_delay"]; ok {
+			seconds, ok := value.(float64)
+			if !ok || seconds < 0 {
+				return validationErrorf("'retry_delay' should be a non-negative number")
+			}
+			delay = time.Duration(seconds * float64(time.Second))
+		}
+		if value, ok := step.Params["retry_on_status"]; ok {
+			codes, ok := value.([]interface{})
+			if !ok {
+				return validationErrorf("'retry_on_status' should be an array of status codes")
+			}
+			for _, code := range codes {
+				statuses = append(statuses, fmt.Sprintf("%v", code))
+			}
+		}
+		if value, ok := step.Params["error_selector"]; ok {
+			if errorSelector, ok = value.(string); !ok {
+				return validationErrorf("'error_selector' should be a string")
+			}
+		}
+		if value, ok := step.Params["ignore_load_errors"]; ok {
+			if ignoreLoadErrors, ok = value.(bool); !ok {
+				return validationErrorf("'ignore_load_errors' should be a boolean")
+			}
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := ctx.WebDriver.Get(step.URL)
+		if err == nil {
+			err = checkErrorPage(ctx, statuses, errorSelector)
+		}
+		if err == nil || attempt >= retries {
+			if !ignoreLoadErrors {
+				return err
+			}
+			if err != nil {
+				log.Printf("Ignoring load error for %s: %v", step.URL, err)
+			}
+			ctx.Variables["navigate_status"] = strconv.Itoa(navigationStatus(ctx))
+			return nil
+		}
+		log.Printf("Navigating to %s failed (%v), retrying in %v (%d attempts left)", step.URL, err, delay, retries-attempt)
+		idle(ctx, delay)
+		delay *= 2
+	}
+}
+
+// navigationStatus returns the HTTP status of the current document from the Navigation Timing API,
+// or 0 if the browser received no response or doesn't report it
+func navigationStatus(ctx *Context) int {
+	result, err := ctx.WebDriver.ExecuteScript(`var entry = performance.getEntriesByType('navigation')[0];
+return entry && entry.responseStatus ? entry.responseStatus : 0;`, nil)
+	if err != nil {
+		return 0
+	}
+	status, _ := result.(float64)
+	return int(status)
+}
+
+// checkErrorPage returns an error if the loaded page's title mentions one of statuses or an element
+// matches errorSelector
+func checkErrorPage(ctx *Context, statuses []string, errorSelector string) error {
+	if len(statuses) > 0 {
+		title, err := ctx.WebDriver.Title()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			if strings.Contains(title, status) {
+				return fmt.Errorf("page title '%s' indicates status %s", title, status)
+			}
+		}
+	}
+	if errorSelector != "" {
+		result, err := ctx.WebDriver.ExecuteScript("return document.querySelector(arguments[0]) !== null;", []interface{}{errorSelector})
+		if err != nil {
+			return err
+		}
+		if found, _ := result.(bool); found {
+			return fmt.Errorf("error page element '%s' is present", errorSelector)
+		}
+	}
+	return nil
+}
+
+func click(ctx *Context, step Step) error {
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	ctx.Humanizer.Pause()
+	return elem.Click()
+}
+
+func doubleClick(ctx *Context, step Step) error {
+	_, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	ctx.Humanizer.Pause()
+	return ctx.WebDriver.DoubleClick()
+}
+
+func rightClick(ctx *Context, step Step) error {
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	ctx.Humanizer.Pause()
+	// Perform right click via JavaScript
+	script := "var evt = new MouseEvent('contextmenu', { bubbles: true, cancelable: true, view: window }); arguments[0].dispatchEvent(evt);"
+	_, err = ctx.WebDriver.ExecuteScript(script, []interface{}{elem})
+	return err
+}
+
+// contextClick right clicks the element through the W3C Actions API, which opens context menus that a
+// synthetic contextmenu event doesn't, then runs the optional 'params.then' steps against the menu
+func contextClick(ctx *Context, step Step) error {
+	var then []Step
+	if step.Params != nil {
+		if value, ok := step.Params["then"]; ok {
+			var err error
+			if then, err = nestedSteps(value); err != nil {
+				return fmt.Errorf("invalid 'params.then': %v", err)
+			}
+		}
+	}
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	origin, err := elementReference(elem)
+	if err != nil {
+		return err
+	}
+	ctx.Humanizer.Pause()
+	err = performPointerActions(ctx, "mouse",
+		map[string]interface{}{"type": "pointerMove", "duration": 0, "origin": origin, "x": 0, "y": 0},
+		map[string]interface{}{"type": "pointerDown", "button": 2},
+		map[string]interface{}{"type": "pointerUp", "button": 2},
+	)
+	if err != nil {
+		return fmt.Errorf("context click failed: %v", err)
+	}
+	return runNestedSteps(ctx, step, "then", then)
+}
+
+// tap taps the element with a touch pointer so touch handlers fire instead of mouse ones. Sessions
+// without touch support, e.g. desktop browsers without mobile emulation, fall back to a click.
+func tap(ctx *Context, step Step) error {
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	result, err := ctx.WebDriver.ExecuteScript("return 'ontouchstart' in window || navigator.maxTouchPoints > 0;", nil)
+	if err != nil {
+		return err
+	}
+	if touch, _ := result.(bool); !touch {
+		log.Printf("Session has no touch support, clicking '%s' instead (enable mobile emulation or use a mobile node to tap)", step.Selector)
+		return elem.Click()
+	}
+	origin, err := elementReference(elem)
+	if err != nil {
+		return err
+	}
+	ctx.Humanizer.Pause()
+	err = performPointerActions(ctx, "touch",
+		map[string]interface{}{"type": "pointerMove", "duration": 0, "origin": origin, "x": 0, "y": 0},
+		map[string]interface{}{"type": "pointerDown", "button": 0},
+		map[string]interface{}{"type": "pointerUp", "button": 0},
+	)
+	if err != nil {
+		return fmt.Errorf("tap failed: %v", err)
+	}
+	return nil
+}
+
+// forEach runs 'params.steps' once per element of 'params.values', with the element stored in the
+// variable named by 'params.as' ("item" by default) and its 0-based position in '<as>_index'.
+// Values that aren't strings are stored in their JSON encoding.
+func forEach(ctx *Context, step Step) error {
+	if step.Params == nil {
+		return validationErrorf("for_each action requires 'params'")
+	}
+	values, ok := step.Params["values"].([]interface{})
+	if !ok {
+		return validationErrorf("for_each action requires 'params.values' as an array")
+	}
+	value, ok := step.Params["steps"]
+	if !ok {
+		return validationErrorf("for_each action requires 'params.steps'")
+	}
+	steps, err := nestedSteps(value)
+	if err != nil {
+		return fmt.Errorf("invalid 'params.steps': %v", err)
+	}
+	name := "item"
+	if value, ok := step.Params["as"]; ok {
+		if name, ok = value.(string); !ok || name == "" {
+			return validationErrorf("'as' should be a non-empty string")
+		}
+	}
+
+	for i, value := range values {
+		item, ok := value.(string)
+		if !ok {
+			data, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			item = string(data)
+		}
+		ctx.Variables[name] = item
+		ctx.Variables[name+"_index"] = strconv.Itoa(i)
+		if err := runNestedSteps(ctx, step, "steps", steps); err != nil {
+			return fmt.Errorf("iteration %d (%s = '%s'): %w", i, name, item, err)
+		}
+	}
+	return nil
+}
+
+// retryUntil runs the 'params.steps' and then the 'params.until' step, repeating both until the
+// until step succeeds or 'params.attempts' (default 5) are used up, waiting 'params.delay' seconds
+// (default 1) between attempts. A typical use clicks a refresh button until a status reads "Complete".
+func retryUntil(ctx *Context, step Step) error {
+	if step.Params == nil {
+		return validationErrorf("retry_until action requires 'params'")
+	}
+	value, ok := step.Params["steps"]
+	if !ok {
+		return validationErrorf("retry_until action requires 'params.steps'")
+	}
+	steps, err := nestedSteps(value)
+	if err != nil {
+		return fmt.Errorf("invalid 'params.steps': %v", err)
+	}
+	value, ok = step.Params["until"]
+	if !ok {
+		return validationErrorf("retry_until action requires 'params.until'")
+	}
+	until, err := nestedSteps([]interface{}{value})
+	if err != nil {
+		return fmt.Errorf("invalid 'params.until': %v", err)
+	}
+	attempts, delay := 5, time.Second
+	if value, ok := step.Params["attempts"]; ok {
+		count, ok := value.(float64)
+		if !ok || count < 1 {
+			return validationErrorf("'attempts' should be a positive number")
+		}
+		attempts = int(count)
+	}
+	if value, ok := step.Params["delay"]; ok {
+		seconds, ok := value.(float64)
+		if !ok || seconds < 0 {
+			return validationErrorf("'delay' should be a non-negative number")
+		}
+		delay = time.Duration(seconds * float64(time.Second))
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := runNestedSteps(ctx, step, "steps", steps); err != nil {
+			return err
+		}
+		err := runNestedSteps(ctx, step, "until", until)
+		if err == nil {
+			return nil
+		}
+		if attempt >= attempts {
+			return fmt.Errorf("condition not met after %d attempts: %v", attempts, err)
+		}
+		log.Printf("Condition not met (attempt %d of %d): %v", attempt, attempts, err)
+		idle(ctx, delay)
+	}
+}
+
+func enterText(ctx *Context, step Step) error {
+	verify := false
+	if step.Params != nil {
+		if value, ok := step.Params["verify"]; ok {
+			if verify, ok = value.(bool); !ok {
+				return validationErrorf("'verify' should be a boolean")
+			}
+		}
+	}
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	keys, literal := expandKeyTokens(step.Text)
+	if !verify {
+		return typeKeys(ctx, elem, keys)
+	}
+
+	// Clear first so the field holds exactly what we typed, then check nothing altered it.
+	// Only the literal characters are compared, special keys are not reflected in the value.
+	if err := elem.Clear(); err != nil {
+		return err
+	}
+	if err := typeKeys(ctx, elem, keys); err != nil {
+		return err
+	}
+	value, err := ctx.WebDriver.ExecuteScript("return arguments[0].value;", []interface{}{elem})
+	if err != nil {
+		return fmt.Errorf("failed to read back value: %v", err)
+	}
+	if actual := fmt.Sprintf("%v", value); actual != literal {
+		return fmt.Errorf("input '%s' rejected text: typed '%s', field contains '%s'", step.Selector, literal, actual)
+	}
+	return nil
+}
+
+// pressKeys presses each entry of step.Keys in turn, while holding the modifiers named in
+// step.OtherKeys. Entries are key names like "Enter", "Tab", "Escape" or "ArrowDown", single
+// characters, or combos like "Ctrl+A". The keys go to the element matching the selector, which is
+// focused first, or to the focused element if there is no selector.
+func pressKeys(ctx *Context, step Step) error {
+	if len(step.Keys) == 0 {
+		return validationErrorf("press_keys action requires 'keys'")
+	}
+	held := make([]string, len(step.OtherKeys))
+	for i, name := range step.OtherKeys {
+		key, err := resolveKey(name)
+		if err != nil {
+			return err
+		}
+		held[i] = key
+	}
+	var actions []interface{}
+	keyAction := func(kind, key string) {
+		actions = append(actions, map[string]string{"type": kind, "value": key})
+	}
+	for _, key := range held {
+		keyAction("keyDown", key)
+	}
+	for _, entry := range step.Keys {
+		names := []string{entry}
+		if len(entry) > 1 && strings.Contains(entry, "+") {
+			names = strings.Split(entry, "+")
+		}
+		keys := make([]string, len(names))
+		for i, name := range names {
+			key, err := resolveKey(strings.TrimSpace(name))
+			if err != nil {
+				return err
+			}
+			keys[i] = key
+		}
+		for _, key := range keys {
+			keyAction("keyDown", key)
+		}
+		for i := len(keys) - 1; i >= 0; i-- {
+			keyAction("keyUp", keys[i])
+		}
+	}
+	for i := len(held) - 1; i >= 0; i-- {
+		keyAction("keyUp", held[i])
+	}
+
+	if step.Selector != "" {
+		elem, err := findStepElement(ctx, step)
+		if err != nil {
+			return err
+		}
+		if _, err := ctx.WebDriver.ExecuteScript("arguments[0].focus();", []interface{}{elem}); err != nil {
+			return fmt.Errorf("failed to focus '%s': %v", step.Selector, err)
+		}
+	}
+	sequence := map[string]interface{}{
+		"actions": []interface{}{
+			map[string]interface{}{"type": "key", "id": "keyboard", "actions": actions},
+		},
+	}
+	if _, err := w3cCommand(ctx, http.MethodPost, "/actions", sequence); err != nil {
+		return err
+	}
+	if _, err := w3cCommand(ctx, http.MethodDelete, "/actions", nil); err != nil {
+		return fmt.Errorf("failed to release keys: %v", err)
+	}
+	return nil
+}
+
+// resolveKey returns the key code for a key name or a single character
+func resolveKey(name string) (string, error) {
+	if key, ok := lookupKey(name); ok {
+		return key, nil
+	}
+	if len([]rune(name)) == 1 {
+		return name, nil
+	}
+	return "", fmt.Errorf("unknown key '%s'", name)
+}
+
+// pasteScript dispatches a paste ClipboardEvent carrying the text. Unless a handler cancels it,
+// the text is inserted at the selection and an input event fired, as the browser would do.
+const pasteScript = `
+var elem = arguments[0], text = arguments[1];
+elem.focus();
+var data = new DataTransfer();
+data.setData('text/plain', text);
+var event = new ClipboardEvent('paste', {clipboardData: data, bubbles: true, cancelable: true});
+if (!elem.dispatchEvent(event)) {
+	return false;
+}
+var inserted = false;
+if (typeof elem.setRangeText === 'function') {
+	try {
+		elem.setRangeText(text, elem.selectionStart, elem.selectionEnd, 'end');
+		inserted = true;
+	} catch (e) {
+		// Inputs without a selection, e.g. type=email or number, throw InvalidStateError
+	}
+}
+if (!inserted && elem.isContentEditable) {
+	document.execCommand('insertText', false, text);
+	return true;
+}
+if (!inserted) {
+	elem.value = (elem.value || '') + text;
+}
+elem.dispatchEvent(new InputEvent('input', {bubbles: true, inputType: 'insertFromPaste', data: text}));
+return true;
+`
+
+// paste simulates pasting step.Text into the element, so paste handlers run as they would for a user
+func paste(ctx *Context, step Step) error {
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	inserted, err := ctx.WebDriver.ExecuteScript(pasteScript, []interface{}{elem, step.Text})
+	if err != nil {
+		return fmt.Errorf("error pasting into '%s': %v", step.Selector, err)
+	}
+	if inserted == false {
+		fmt.Fprintf(console, "Paste into '%s' was cancelled by the page\n", step.Selector)
+	}
+	return nil
+}
+
+func clearText(ctx *Context, step Step) error {
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	return elem.Clear()
+}
+
+func selectOption(ctx *Context, step Step) error {
+	valueStr := step.Value
+	if valueStr == "" {
+		value, ok := step.Params["value"]
+		if !ok {
+			return validationErrorf("select_option action requires 'value' or 'params.value'")
+		}
+		if valueStr, ok = value.(string); !ok {
+			return validationErrorf("'value' should be a string")
+		}
+	}
+
+	// Find the select element
+	selectElem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+
+	// Find the option with the specified value
+	optionSelector := fmt.Sprintf("option[value='%s']", valueStr)
+	optionElem, err := selectElem.FindElement(selenium.ByCSSSelector, optionSelector)
+	if err != nil {
+		return fmt.Errorf("option with value '%s' not found", valueStr)
+	}
+
+	// Click the option to select it
+	return optionElem.Click()
+}
+
+func deselectOption(ctx *Context, step Step) error {
+	if step.Params == nil {
+		return validationErrorf("deselect_option action requires 'params'")
+	}
+	value, ok := step.Params["value"]
+	if !ok {
+		return validationErrorf("deselect_option action requires 'params.value'")
+	}
+	valueStr, ok := value.(string)
+	if !ok {
+		return validationErrorf("'value' should be a string")
+	}
+
+	// Find the select element
+	selectElem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+
+	// Find the option with the specified value
+	optionSelector := fmt.Sprintf("option[value='%s']", valueStr)
+	optionElem, err := selectElem.FindElement(selenium.ByCSSSelector, optionSelector)
+	if err != nil {
+		return fmt.Errorf("option with value '%s' not found", valueStr)
+	}
+
+	// Deselect the option by clicking it (if multi-select)
+	// Note: The tebeka/selenium package does not provide a direct Deselect method
+	// We'll use JavaScript to deselect the option
+	script := "arguments[0].selected = false;"
+	_, err = ctx.WebDriver.ExecuteScript(script, []interface{}{optionElem})
+	if err != nil {
+		return fmt.Errorf("failed to deselect option with value '%s': %v", valueStr, err)
+	}
+
+	return nil
+}
+
+// getSelectedOption stores the selected option's value as 'store_result_as' and its text as
+// '<store_result_as>_text'. For multi-selects the first selected option is used.
+func getSelectedOption(ctx *Context, step Step) error {
+	if step.StoreResultAs == "" {
+		return validationErrorf("get_selected_option action requires 'store_result_as'")
+	}
+	selectElem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	script := `
+	var option = arguments[0].selectedOptions[0];
+	return option ? [option.value, option.text] : null;
+	`
+	result, err := ctx.WebDriver.ExecuteScript(script, []interface{}{selectElem})
+	if err != nil {
+		return err
+	}
+	option, ok := result.([]interface{})
+	if !ok || len(option) != 2 {
+		return fmt.Errorf("no option selected in '%s'", step.Selector)
+	}
+	ctx.Variables[step.StoreResultAs] = fmt.Sprintf("%v", option[0])
+	ctx.Variables[step.StoreResultAs+"_text"] = fmt.Sprintf("%v", option[1])
+	return nil
+}
+
+func getText(ctx *Context, step Step) error {
+	if step.StoreResultAs == "" {
+		return validationErrorf("get_text action requires 'store_result_as'")
+	}
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	text, err := elementText(ctx, elem, step)
+	if err != nil {
+		return err
+	}
+	ctx.Variables[step.StoreResultAs] = text
+	return nil
+}
+
+func getAttribute(ctx *Context, step Step) error {
+	if step.StoreResultAs == "" {
+		return validationErrorf("get_attribute action requires 'store_result_as'")
+	}
+	if step.Params == nil {
+		return validationErrorf("get_attribute action requires 'params'")
+	}
+	attr, ok := step.Params["attribute"]
+	if !ok {
+		return validationErrorf("get_attribute action requires 'params.attribute'")
+	}
+	attrStr, ok := attr.(string)
+	if !ok {
+		return validationErrorf("'attribute' should be a string")
+	}
+	property := false
+	if value, ok := step.Params["property"]; ok {
+		if property, ok = value.(bool); !ok {
+			return validationErrorf("'property' should be a boolean")
+		}
+	}
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	var value string
+	if property {
+		value, err = elementProperty(ctx, elem, attrStr)
+	} else {
+		value, err = elem.GetAttribute(attrStr)
+	}
+	if err != nil {
+		return err
+	}
+	ctx.Variables[step.StoreResultAs] = value
+	return nil
+}
+
+// getProperty stores the live DOM property 'params.property' of the element, e.g. the current
+// value of an input rather than its initial value attribute
+func getProperty(ctx *Context, step Step) error {
+	if step.StoreResultAs == "" {
+		return validationErrorf("get_property action requires 'store_result_as'")
+	}
+	if step.Params == nil {
+		return validationErrorf("get_property action requires 'params'")
+	}
+	name, ok := step.Params["property"].(string)
+	if !ok {
+		return validationErrorf("get_property action requires 'params.property' as a string")
+	}
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	value, err := elementProperty(ctx, elem, name)
+	if err != nil {
+		return err
+	}
+	ctx.Variables[step.StoreResultAs] = value
+	return nil
+}
+
+// getElementCount stores the number of elements matching the selector in 'store_result_as'
+func getElementCount(ctx *Context, step Step) error {
+	if step.StoreResultAs == "" {
+		return validationErrorf("get_element_count action requires 'store_result_as'")
+	}
+	elems, err := findStepElements(ctx, step)
+	if err != nil {
+		return err
+	}
+	ctx.Variables[step.StoreResultAs] = strconv.Itoa(len(elems))
+	return nil
+}
+
+// getAllText stores the texts of all elements matching the selector, in document order, as a JSON
+// array in 'store_result_as'
+func getAllText(ctx *Context, step Step) error {
+	if step.StoreResultAs == "" {
+		return validationErrorf("get_all_text action requires 'store_result_as'")
+	}
+	elems, err := findStepElements(ctx, step)
+	if err != nil {
+		return err
+	}
+	texts := make([]string, len(elems))
+	for i, elem := range elems {
+		if texts[i], err = elementText(ctx, elem, step); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(texts)
+	if err != nil {
+		return err
+	}
+	ctx.Variables[step.StoreResultAs] = string(data)
+	return nil
+}
+
+// ElementSnapshot is the state of an element as captured by snapshot_element. Properties that
+// couldn't be read are left out and their errors listed under Errors.
+type ElementSnapshot struct {
+	Selector   string            `json:"selector"`
+	TagName    string            `json:"tag_name,omitempty"`
+	Text       string            `json:"text"`
+	Attributes map[string]string `json:"attributes"`
+	Styles     map[string]string `json:"styles"`
+	Displayed  *bool             `json:"displayed,omitempty"`
+	Enabled    *bool             `json:"enabled,omitempty"`
+	Selected   *bool             `json:"selected,omitempty"`
+	Location   *selenium.Point   `json:"location,omitempty"`
+	Size       *selenium.Size    `json:"size,omitempty"`
+	Errors     map[string]string `json:"errors,omitempty"`
+}
+
+var (
+	defaultSnapshotAttributes = []string{"id", "class", "name", "type", "value", "href", "src", "role", "aria-label", "disabled"}
+	defaultSnapshotStyles     = []string{"display", "visibility", "opacity", "color", "background-color", "font-size"}
+)
+
+// snapshotElement captures an ElementSnapshot as JSON into 'store_result_as' and/or 'filename'.
+// 'params.attributes' and 'params.styles' replace the default lists of attributes and computed styles.
+func snapshotElement(ctx *Context, step Step) error {
+	if step.StoreResultAs == "" && step.Filename == "" {
+		return validationErrorf("snapshot_element action requires 'store_result_as' or 'filename'")
+	}
+	attributes, styles := defaultSnapshotAttributes, defaultSnapshotStyles
+	if step.Params != nil {
+		for key, names := range map[string]*[]string{"attributes": &attributes, "styles": &styles} {
+			value, ok := step.Params[key]
+			if !ok {
+				continue
+			}
+			list, ok := value.([]interface{})
+			if !ok {
+				return validationErrorf("'%s' should be an array of strings", key)
+			}
+			*names = nil
+			for _, item := range list {
+				name, ok := item.(string)
+				if !ok {
+					return validationErrorf("'%s' should be an array of strings", key)
+				}
+				*names = append(*names, name)
+			}
+		}
+	}
+
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+
+	snapshot := ElementSnapshot{
+		Selector:   step.Selector,
+		Attributes: map[string]string{},
+		Styles:     map[string]string{},
+		Errors:     map[string]string{},
+	}
+	if snapshot.TagName, err = elem.TagName(); err != nil {
+		snapshot.Errors["tag_name"] = err.Error()
+	}
+	if snapshot.Text, err = elem.Text(); err != nil {
+		snapshot.Errors["text"] = err.Error()
+	}
+	for _, name := range attributes {
+		// Absent attributes are reported as an error by the driver, leave them out
+		if value, err := elem.GetAttribute(name); err == nil {
+			snapshot.Attributes[name] = value
+		}
+	}
+	for _, name := range styles {
+		if value, err := elem.CSSProperty(name); err != nil {
+			snapshot.Errors["style "+name] = err.Error()
+		} else {
+			snapshot.Styles[name] = value
+		}
+	}
+	for name, read := range map[string]struct {
+		get  func() (bool, error)
+		dest **bool
+	}{
+		"displayed": {elem.IsDisplayed, &snapshot.Displayed},
+		"enabled":   {elem.IsEnabled, &snapshot.Enabled},
+		"selected":  {elem.IsSelected, &snapshot.Selected},
+	} {
+		if flag, err := read.get(); err != nil {
+			snapshot.Errors[name] = err.Error()
+		} else {
+			*read.dest = &flag
+		}
+	}
+	if snapshot.Location, err = elem.Location(); err != nil {
+		snapshot.Errors["location"] = err.Error()
+	}
+	if snapshot.Size, err = elem.Size(); err != nil {
+		snapshot.Errors["size"] = err.Error()
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	if step.StoreResultAs != "" {
+		ctx.Variables[step.StoreResultAs] = string(data)
+	}
+	if step.Filename != "" {
+		if err := os.WriteFile(step.Filename, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("error writing snapshot to %s: %v", step.Filename, err)
+		}
+	}
+	return nil
+}
+
+func waitDuration(ctx *Context, step Step) error {
+	duration := time.Duration(step.WaitDuration) * time.Second
+	return sleepWithKeepAlive(ctx, duration)
+}
+
+// idle sleeps for duration with ctx.DriverLock released, so background recorders can use the
+// driver while a step waits or polls. Steps run with the lock held.
+func idle(ctx *Context, duration time.Duration) {
+	ctx.DriverLock.Unlock()
+	defer ctx.DriverLock.Lock()
+	time.Sleep(duration)
+}
+
+// sleepWithKeepAlive sleeps for duration, issuing a cheap command every ctx.KeepAlive so remote
+// sessions aren't closed for inactivity. Polling waits talk to the driver anyway and don't need it.
+func sleepWithKeepAlive(ctx *Context, duration time.Duration) error {
+	if ctx.KeepAlive <= 0 {
+		idle(ctx, duration)
+		return nil
+	}
+	endTime := time.Now().Add(duration)
+	for {
+		remaining := time.Until(endTime)
+		if remaining <= 0 {
+			return nil
+		}
+		if remaining > ctx.KeepAlive {
+			remaining = ctx.KeepAlive
+		}
+		idle(ctx, remaining)
+		if _, err := ctx.WebDriver.CurrentURL(); err != nil {
+			return fmt.Errorf("keepalive failed: %v", err)
+		}
+	}
+}
+
+// waitForStable polls the element's location and size until two consecutive polls agree, meaning any
+// animation or transition moving it has settled. 'params.interval' sets the poll interval in milliseconds.
+func waitForStable(ctx *Context, step Step) error {
+	interval := 100 * time.Millisecond
+	if step.Params != nil {
+		if value, ok := step.Params["interval"]; ok {
+			ms, ok := value.(float64)
+			if !ok || ms <= 0 {
+				return validationErrorf("'interval' should be a positive number")
+			}
+			interval = time.Duration(ms) * time.Millisecond
+		}
+	}
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	endTime := time.Now().Add(time.Duration(step.Timeout) * time.Second)
+
+	var lastLocation *selenium.Point
+	var lastSize *selenium.Size
+	for {
+		location, err := elem.Location()
+		if err != nil {
+			return err
+		}
+		size, err := elem.Size()
+		if err != nil {
+			return err
+		}
+		if lastLocation != nil && *location == *lastLocation && *size == *lastSize {
+			return nil
+		}
+		// Two samples are needed to tell whether the element moved, even with a zero timeout
+		if lastLocation != nil && time.Now().After(endTime) {
+			return fmt.Errorf("element '%s' did not stop moving after %d seconds (last at %d,%d size %dx%d)",
+				step.Selector, step.Timeout, location.X, location.Y, size.Width, size.Height)
+		}
+		lastLocation, lastSize = location, size
+		idle(ctx, interval)
+	}
+}
+
+// waitForElementState polls until the element is displayed or, with clickable set, displayed and
+// enabled. The element must appear and reach that state within the step's timeout.
+func waitForElementState(ctx *Context, step Step, clickable bool) error {
+	if step.Selector == "" {
+		return validationErrorf("%s action requires 'selector'", step.Action)
+	}
+	condition := "visible"
+	if clickable {
+		condition = "clickable"
+	}
+	endTime := time.Now().Add(time.Duration(step.Timeout) * time.Second)
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	for {
+		ready, err := elem.IsDisplayed()
+		if err != nil {
+			return err
+		}
+		if ready && clickable {
+			if ready, err = elem.IsEnabled(); err != nil {
+				return err
+			}
+		}
+		if ready {
+			return nil
+		}
+		if time.Now().After(endTime) {
+			return fmt.Errorf("element '%s' not %s after %d seconds", step.Selector, condition, step.Timeout)
+		}
+		idle(ctx, 500*time.Millisecond)
+	}
+}
+
+// waitForRemoved polls until no element matches the selector any more. Unlike a visibility check it
+// only succeeds once the element is detached from the DOM.
+func waitForRemoved(ctx *Context, step Step) error {
+	if step.Selector == "" {
+		return validationErrorf("wait_for_removed action requires 'selector'")
+	}
+	if err := requireCSSSelector(step); err != nil {
+		return err
+	}
+	endTime := time.Now().Add(time.Duration(step.Timeout) * time.Second)
+	seen := false
+	for {
+		// Count via script, FindElements would block for the implicit wait while nothing matches
+		result, err := ctx.WebDriver.ExecuteScript("return document.querySelectorAll(arguments[0]).length;", []interface{}{step.Selector})
+		if err != nil {
+			return err
+		}
+		if count, _ := result.(float64); count == 0 {
+			if !seen {
+				fmt.Fprintf(console, "Element '%s' was not present when waiting for its removal\n", step.Selector)
+			}
+			return nil
+		}
+		seen = true
+		if time.Now().After(endTime) {
+			return fmt.Errorf("element '%s' still present after %d seconds", step.Selector, step.Timeout)
+		}
+		idle(ctx, 500*time.Millisecond)
+	}
+}
+
+// waitForElements polls until all (or, with all false, any) of the 'params.selectors' match an
+// element. For wait_for_any the first matching selector is stored in 'store_result_as' if set, so
+// later steps can branch on it.
+func waitForElements(ctx *Context, step Step, all bool) error {
+	if step.Params == nil {
+		return validationErrorf("%s action requires 'params'", step.Action)
+	}
+	list, ok := step.Params["selectors"].([]interface{})
+	if !ok || len(list) == 0 {
+		return validationErrorf("%s action requires 'params.selectors' as a non-empty array", step.Action)
+	}
+	selectors := make([]interface{}, len(list))
+	for i, v := range list {
+		if _, ok := v.(string); !ok {
+			return validationErrorf("'selectors' should only contain strings")
+		}
+		selectors[i] = v
+	}
+	if err := requireCSSSelector(step); err != nil {
+		return err
+	}
+	script := `
+	var found = [];
+	for (var i = 0; i < arguments.length; i++) {
+		found.push(document.querySelector(arguments[i]) !== null);
+	}
+	return found;
+	`
+	endTime := time.Now().Add(time.Duration(step.Timeout) * time.Second)
+	for {
+		result, err := ctx.WebDriver.ExecuteScript(script, selectors)
+		if err != nil {
+			return err
+		}
+		found, _ := result.([]interface{})
+		var missing []string
+		for i, selector := range selectors {
+			if i < len(found) && found[i] == true {
+				if !all {
+					if step.StoreResultAs != "" {
+						ctx.Variables[step.StoreResultAs] = selector.(string)
+					}
+					return nil
+				}
+				continue
+			}
+			missing = append(missing, selector.(string))
+		}
+		if all && len(missing) == 0 {
+			return nil
+		}
+		if time.Now().After(endTime) {
+			if all {
+				return fmt.Errorf("elements ['%s'] not found after %d seconds", strings.Join(missing, "', '"), step.Timeout)
+			}
+			return fmt.Errorf("none of ['%s'] found after %d seconds", strings.Join(missing, "', '"), step.Timeout)
+		}
+		idle(ctx, 500*time.Millisecond)
+	}
+}
+
+// waitForTitle polls the page title until it matches the expected value using 'params.mode'
+func waitForTitle(ctx *Context, step Step) error {
+	expected, err := expectedValues(step, false)
+	if err != nil {
+		return err
+	}
+	mode, err := matchMode(step, "equals")
+	if err != nil {
+		return err
+	}
+	endTime := time.Now().Add(time.Duration(step.Timeout) * time.Second)
+	for {
+		title, err := ctx.WebDriver.Title()
+		if err != nil {
+			return err
+		}
+		matched, err := matchAny(mode, title, expected)
+		if err != nil {
+			return err
+		}
+		if matched {
+			return nil
+		}
+		if time.Now().After(endTime) {
+			return fmt.Errorf("title did not match %s (%s) after %d seconds, last seen '%s'", describeExpected(expected), mode, step.Timeout, title)
+		}
+		idle(ctx, 500*time.Millisecond)
+	}
+}
+
+// jqueryStateScript reports "missing" without jQuery, "busy" while AJAX requests are in flight
+// (or, with arguments[0], the document is still loading) and "idle" otherwise
+const jqueryStateScript = `
+if (typeof window.jQuery === 'undefined') {
+	return 'missing';
+}
+if (window.jQuery.active !== 0 || (arguments[0] && document.readyState !== 'complete')) {
+	return 'busy';
+}
+return 'idle';
+`
+
+// waitForJQuery waits until jQuery has no active AJAX requests. With 'params.ready_state' it also
+// waits for document.readyState to be complete. Pages without jQuery only produce a warning.
+func waitForJQuery(ctx *Context, step Step) error {
+	readyState := false
+	if step.Params != nil {
+		if value, ok := step.Params["ready_state"]; ok {
+			if readyState, ok = value.(bool); !ok {
+				return validationErrorf("'ready_state' should be a boolean")
+			}
+		}
+	}
+	endTime := time.Now().Add(time.Duration(step.Timeout) * time.Second)
+	for {
+		state, err := ctx.WebDriver.ExecuteScript(jqueryStateScript, []interface{}{readyState})
+		if err != nil {
+			return err
+		}
+		switch state {
+		case "missing":
+			log.Printf("Warning: jQuery not present on the page, not waiting")
+			return nil
+		case "idle":
+			return nil
+		}
+		if time.Now().After(endTime) {
+			return fmt.Errorf("jQuery still busy after %d seconds", step.Timeout)
+		}
+		idle(ctx, 500*time.Millisecond)
+	}
+}
+
+// takeScreenshot saves a screenshot of the viewport, or with 'params.full_page' of the whole
+// document where the browser supports it
+func takeScreenshot(ctx *Context, step Step) error {
+	filename := step.Filename
+	if filename == "" {
+		filename = fmt.Sprintf("screenshot_%d.png", time.Now().Unix())
+	}
+	fullPage := false
+	if value, ok := step.Params["full_page"]; ok {
+		if fullPage, ok = value.(bool); !ok {
+			return validationErrorf("'full_page' should be a boolean")
+		}
+	}
+	if !fullPage {
+		return saveScreenshot(ctx, filename)
+	}
+	data, err := fullPageScreenshot(ctx)
+	if err != nil {
+		log.Printf("Full page screenshot unavailable (%v), capturing the viewport only", err)
+		return saveScreenshot(ctx, filename)
+	}
+	log.Printf("Captured full page screenshot using %s", ctx.Browser)
+	return os.WriteFile(filename, data, 0644)
+}
+
+// fullPageScreenshot captures the whole document as PNG, using geckodriver's full page endpoint in
+// Firefox and DevTools capturing beyond the viewport in Chrome
+func fullPageScreenshot(ctx *Context) ([]byte, error) {
+	var encoded string
+	switch ctx.Browser {
+	case "firefox":
+		result, err := w3cCommand(ctx, http.MethodGet, "/moz/screenshot/full", nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(result, &encoded); err != nil {
+			return nil, fmt.Errorf("unexpected screenshot reply: %v", err)
+		}
+	case "chrome", "edge":
+		result, err := devToolsCommand(ctx, "Page.getLayoutMetrics", nil)
+		if err != nil {
+			return nil, err
+		}
+		var metrics struct {
+			ContentSize struct {
+				Width  float64 `json:"width"`
+				Height float64 `json:"height"`
+			} `json:"cssContentSize"`
+		}
+		if err := json.Unmarshal(result, &metrics); err != nil {
+			return nil, fmt.Errorf("unexpected layout metrics: %v", err)
+		}
+		result, err = devToolsCommand(ctx, "Page.captureScreenshot", map[string]interface{}{
+			"format":                "png",
+			"captureBeyondViewport": true,
+			"clip": map[string]interface{}{
+				"x": 0, "y": 0, "scale": 1,
+				"width":  metrics.ContentSize.Width,
+				"height": metrics.ContentSize.Height,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		var screenshot struct {
+			Data string `json:"data"`
+		}
+		if err := json.Unmarshal(result, &screenshot); err != nil {
+			return nil, fmt.Errorf("unexpected screenshot reply: %v", err)
+		}
+		encoded = screenshot.Data
+	default:
+		return nil, fmt.Errorf("not supported in %s", ctx.Browser)
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// takeElementScreenshot saves a screenshot of just the element, taken by the driver if it supports
+// element screenshots. Otherwise, or with 'params.padding' pixels of surrounding context added on
+// each side, the viewport screenshot is cropped to the element. Zero-size elements are an error.
+func takeElementScreenshot(ctx *Context, step Step) error {
+	padding := 0.0
+	if step.Params != nil {
+		if value, ok := step.Params["padding"]; ok {
+			if padding, ok = value.(float64); !ok || padding < 0 {
+				return validationErrorf("'padding' should be a non-negative number")
+			}
+		}
+	}
+	filename := step.Filename
+	if filename == "" {
+		filename = fmt.Sprintf("screenshot_%d.png", time.Now().Unix())
+	}
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+
+	script := `
+	arguments[0].scrollIntoView({block: 'nearest', inline: 'nearest'});
+	var r = arguments[0].getBoundingClientRect();
+	return [r.left, r.top, r.width, r.height, window.devicePixelRatio || 1];
+	`
+	result, err := ctx.WebDriver.ExecuteScript(script, []interface{}{elem})
+	if err != nil {
+		return err
+	}
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 5 {
+		return fmt.Errorf("unexpected result from element rect: %v", result)
+	}
+	rect := make([]float64, len(values))
+	for i, v := range values {
+		if rect[i], ok = v.(float64); !ok {
+			return fmt.Errorf("unexpected result from element rect: %v", result)
+		}
+	}
+	if rect[2] <= 0 || rect[3] <= 0 {
+		return fmt.Errorf("element '%s' has zero size (%vx%v), nothing to capture", step.Selector, rect[2], rect[3])
+	}
+	if padding == 0 {
+		// Prefer the driver's own element screenshot, which can capture elements larger than the viewport
+		data, err := elem.Screenshot(false)
+		if err == nil {
+			return os.WriteFile(filename, data, 0644)
+		}
+		log.Printf("Native element screenshot failed (%v), cropping the viewport instead", err)
+	}
+	scale := rect[4]
+	bounds := image.Rect(
+		int((rect[0]-padding)*scale),
+		int((rect[1]-padding)*scale),
+		int((rect[0]+rect[2]+padding)*scale),
+		int((rect[1]+rect[3]+padding)*scale),
+	)
+
+	data, err := ctx.WebDriver.Screenshot()
+	if err != nil {
+		return err
+	}
+	return writeCroppedPNG(data, bounds, filename)
+}
+
+// writeCroppedPNG decodes a PNG screenshot, crops it to bounds (clipped to the image) and writes
+// the result to filename
+func writeCroppedPNG(data []byte, bounds image.Rectangle, filename string) error {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode screenshot: %v", err)
+	}
+	bounds = bounds.Intersect(img.Bounds())
+	if bounds.Empty() {
+		return errors.New("element is outside the captured screenshot")
+	}
+	cropper, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	if !ok {
+		return errors.New("screenshot image does not support cropping")
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, cropper.SubImage(bounds)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// executeScript runs step.Script with 'params.args' as its arguments, or, without an args array,
+// the params object as arguments[0]. The result is stored in 'store_result_as' if set.
+func executeScript(ctx *Context, step Step) error {
+	if step.Script == "" {
+		return validationErrorf("execute_script action requires 'script'")
+	}
+	args, err := scriptArgs(step)
+	if err != nil {
+		return err
+	}
+	result, err := ctx.WebDriver.ExecuteScript(step.Script, args)
+	if err != nil {
+		return err
+	}
+	if step.StoreResultAs != "" {
+		if ctx.Variables[step.StoreResultAs], err = scriptResultString(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executeAsyncScript runs step.Script asynchronously, with arguments as for execute_script. The
+// driver appends a callback as the last argument, which the script must call with its result,
+// e.g. "arguments[arguments.length - 1](value)"; that value is stored in 'store_result_as' if set.
+// The script fails if it doesn't call back within the step's timeout.
+func executeAsyncScript(ctx *Context, step Step) (err error) {
+	if step.Script == "" {
+		return validationErrorf("execute_async_script action requires 'script'")
+	}
+	args, err := scriptArgs(step)
+	if err != nil {
+		return err
+	}
+	// The script timeout applies to the whole session, so the previous one is put back afterwards
+	reply, err := w3cCommand(ctx, http.MethodGet, "/timeouts", nil)
+	if err != nil {
+		return fmt.Errorf("failed to read script timeout: %v", err)
+	}
+	var timeouts struct {
+		Script json.RawMessage `json:"script"`
+	}
+	if err := json.Unmarshal(reply, &timeouts); err != nil {
+		return fmt.Errorf("unexpected reply reading timeouts: %s", reply)
+	}
+	if err := ctx.WebDriver.SetAsyncScriptTimeout(time.Duration(step.Timeout) * time.Second); err != nil {
+		return fmt.Errorf("failed to set script timeout: %v", err)
+	}
+	defer func() {
+		if _, rerr := w3cCommand(ctx, http.MethodPost, "/timeouts", map[string]json.RawMessage{"script": timeouts.Script}); rerr != nil && err == nil {
+			err = fmt.Errorf("failed to restore script timeout: %v", rerr)
+		}
+	}()
+	result, err := ctx.WebDriver.ExecuteScriptAsync(step.Script, args)
+	if err != nil {
+		return err
+	}
+	if step.StoreResultAs != "" {
+		if ctx.Variables[step.StoreResultAs], err = scriptResultString(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scriptArgs returns the arguments for a script step
+func scriptArgs(step Step) ([]interface{}, error) {
+	if value, ok := step.Params["args"]; ok {
+		args, ok := value.([]interface{})
+		if !ok {
+			return nil, validationErrorf("'args' should be an array")
+		}
+		return args, nil
+	}
+	if len(step.Params) > 0 {
+		return []interface{}{step.Params}, nil
+	}
+	return []interface{}{}, nil
+}
+
+// scriptResultString converts a script result for storing in a variable: strings are kept as
+// they are, null becomes empty and anything else is JSON encoded
+func scriptResultString(result interface{}) (string, error) {
+	switch v := result.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode script result: %v", err)
+		}
+		return string(data), nil
+	}
+}
+
+// scroll scrolls the window 'params.amount' pixels (default 100) in 'params.direction', or with
+// 'params.to' set to "top" or "bottom" to that end of the page
+func scroll(ctx *Context, step Step) error {
+	if step.Params == nil {
+		return validationErrorf("scroll action requires 'params'")
+	}
+	if to, ok := step.Params["to"]; ok {
+		toStr, ok := to.(string)
+		if !ok {
+			return validationErrorf("'to' should be a string")
+		}
+		var script string
+		switch strings.ToLower(toStr) {
+		case "top":
+			script = "window.scrollTo(window.scrollX, 0);"
+		case "bottom":
+			script = "window.scrollTo(window.scrollX, document.documentElement.scrollHeight);"
+		default:
+			return validationErrorf("'to' should be top or bottom")
+		}
+		_, err := ctx.WebDriver.ExecuteScript(script, nil)
+		return err
+	}
+	direction, ok := step.Params["direction"]
+	if !ok {
+		return validationErrorf("scroll action requires 'params.direction' or 'params.to'")
+	}
+	directionStr, ok := direction.(string)
+	if !ok {
+		return validationErrorf("'direction' should be a string")
+	}
+	amount := 100.0
+	if value, ok := step.Params["amount"]; ok {
+		if amount, ok = value.(float64); !ok || amount < 0 {
+			return validationErrorf("'amount' should be a non-negative number")
+		}
+	}
+
+	var x, y float64
+	switch strings.ToLower(directionStr) {
+	case "up":
+		y = -amount
+	case "down":
+		y = amount
+	case "left":
+		x = -amount
+	case "right":
+		x = amount
+	default:
+		return errors.New("invalid scroll direction")
+	}
+
+	_, err := ctx.WebDriver.ExecuteScript("window.scrollBy(arguments[0], arguments[1]);", []interface{}{x, y})
+	return err
+}
+
+// scrollToElement scrolls the element into the middle of the viewport
+func scrollToElement(ctx *Context, step Step) error {
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	_, err = ctx.WebDriver.ExecuteScript("arguments[0].scrollIntoView({block: 'center', inline: 'center'});", []interface{}{elem})
+	return err
+}
+
+// scrollToPercent scrolls to 'params.percent' of the page height and/or 'params.horizontal_percent'
+// of its width, each clamped to [0, 100]
+func scrollToPercent(ctx *Context, step Step) error {
+	if step.Params == nil {
+		return validationErrorf("scroll_to_percent action requires 'params'")
+	}
+	percents := []interface{}{nil, nil}
+	for i, key := range []string{"horizontal_percent", "percent"} {
+		value, ok := step.Params[key]
+		if !ok {
+			continue
+		}
+		percent, ok := value.(float64)
+		if !ok {
+			return validationErrorf("'%s' should be a number", key)
+		}
+		percents[i] = math.Max(0, math.Min(100, percent)) / 100
+	}
+	if percents[0] == nil && percents[1] == nil {
+		return validationErrorf("scroll_to_percent action requires 'params.percent' or 'params.horizontal_percent'")
+	}
+
+	// A missing axis keeps its current scroll position
+	script := `
+	var root = document.scrollingElement || document.documentElement;
+	var x = arguments[0] === null ? window.scrollX : (root.scrollWidth - window.innerWidth) * arguments[0];
+	var y = arguments[1] === null ? window.scrollY : (root.scrollHeight - window.innerHeight) * arguments[1];
+	window.scrollTo(Math.max(0, x), Math.max(0, y));
+	`
+	_, err := ctx.WebDriver.ExecuteScript(script, percents)
+	return err
+}
+
+// hoverScript dispatches the events of a pointer entering the element at the given offset from its
+// center, for drivers without W3C Actions. CSS :hover rules don't react to synthetic events.
+const hoverScript = `
+var elem = arguments[0], rect = elem.getBoundingClientRect();
+var init = {
+	bubbles: true, cancelable: true, view: window,
+	clientX: rect.left + rect.width / 2 + arguments[1],
+	clientY: rect.top + rect.height / 2 + arguments[2]
+};
+['pointerover', 'pointerenter', 'mouseover', 'mouseenter', 'pointermove', 'mousemove'].forEach(function (type) {
+	var event = type.indexOf('pointer') === 0 ? new PointerEvent(type, init) : new MouseEvent(type, init);
+	elem.dispatchEvent(event);
+});
+`
+
+// hover moves the mouse to the center of the element, shifted by 'params.x' and 'params.y' pixels.
+// All supported browsers get a real pointer move through W3C Actions; should the driver reject
+// those, mouse events are dispatched by script instead, which triggers JavaScript hover handlers
+// but not CSS :hover styles.
+func hover(ctx *Context, step Step) error {
+	offset := map[string]float64{"x": 0, "y": 0}
+	for key := range offset {
+		if value, ok := step.Params[key]; ok {
+			number, ok := value.(float64)
+			if !ok {
+				return validationErrorf("'%s' should be a number", key)
+			}
+			offset[key] = number
+		}
+	}
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	origin, err := elementReference(elem)
+	if err == nil {
+		// An element origin places the pointer relative to the element's center
+		err = performPointerActions(ctx, "mouse",
+			map[string]interface{}{"type": "pointerMove", "duration": 0, "origin": origin, "x": int(offset["x"]), "y": int(offset["y"])},
+		)
+	}
+	if err == nil {
+		return nil
+	}
+	log.Printf("Hovering with W3C Actions failed (%v), dispatching mouse events instead", err)
+	_, err = ctx.WebDriver.ExecuteScript(hoverScript, []interface{}{elem, offset["x"], offset["y"]})
+	return err
+}
+
+// dragAndDrop drags the element matching 'selector' onto the one matching 'element_selector', or
+// 'params.source_selector' onto 'params.target_selector'
+func dragAndDrop(ctx *Context, step Step) error {
+	sourceSel, targetSel := step.Selector, step.ElementSelector
+	if sourceSel == "" || targetSel == "" {
+		if step.Params == nil {
+			return validationErrorf("drag_and_drop action requires 'selector' and 'element_selector', or 'params'")
+		}
+		sourceSelector, ok := step.Params["source_selector"]
+		if !ok {
+			return validationErrorf("drag_and_drop action requires 'params.source_selector'")
+		}
+		targetSelector, ok := step.Params["target_selector"]
+		if !ok {
+			return validationErrorf("drag_and_drop action requires 'params.target_selector'")
+		}
+		if sourceSel, ok = sourceSelector.(string); !ok {
+			return validationErrorf("'source_selector' should be a string")
+		}
+		if targetSel, ok = targetSelector.(string); !ok {
+			return validationErrorf("'target_selector' should be a string")
+		}
+	}
+
+	sourceElem, err := findElement(ctx, step.By, sourceSel, step.Timeout)
+	if err != nil {
+		return err
+	}
+	targetElem, err := findElement(ctx, step.By, targetSel, step.Timeout)
+	if err != nil {
+		return err
+	}
+
+	// Perform drag and drop via JavaScript
+	script := `
+	function simulateDragDrop(sourceNode, destinationNode) {
+	    var EVENT_TYPES = {
+	        DRAG_END: 'dragend',
+	        DRAG_START: 'dragstart',
+	        DROP: 'drop'
+	    }
+
+	    function createCustomEvent(type) {
+	        var event = new CustomEvent("CustomEvent")
+	        event.initCustomEvent(type, true, true, null)
+	        event.dataTransfer = {
+	            data: {},
+	            setData: function(type, val) {
+	                this.data[type] = val
+	            },
+	            getData: function(type) {
+	                return this.data[type]
+	            }
+	        }
+	        return event
+	    }
+
+	    function dispatchEvent(node, type, event) {
+	        if (node.dispatchEvent) {
+	            return node.dispatchEvent(event)
+	        }
+	        if (node.fireEvent) {
+	            return node.fireEvent("on" + type, event)
+	        }
+	    }
+
+	    var dragStartEvent = createCustomEvent(EVENT_TYPES.DRAG_START)
+	    dispatchEvent(sourceNode, EVENT_TYPES.DRAG_START, dragStartEvent)
+
+	    var dropEvent = createCustomEvent(EVENT_TYPES.DROP)
+	    dropEvent.dataTransfer = dragStartEvent.dataTransfer
+	    dispatchEvent(destinationNode, EVENT_TYPES.DROP, dropEvent)
+
+	    var dragEndEvent = createCustomEvent(EVENT_TYPES.DRAG_END)
+	    dragEndEvent.dataTransfer = dragStartEvent.dataTransfer
+	    dispatchEvent(sourceNode, EVENT_TYPES.DRAG_END, dragEndEvent)
+	}
+	simulateDragDrop(arguments[0], arguments[1])
+	`
+	_, err = ctx.WebDriver.ExecuteScript(script, []interface{}{sourceElem, targetElem})
+	return err
+}
+
+func switchToFrame(ctx *Context, step Step) error {
+	if step.Selector == "" {
+		return validationErrorf("switch_to_frame action requires 'selector' for the iframe")
+	}
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	return ctx.WebDriver.SwitchFrame(elem)
+}
+
+// frameElementScript finds a frame by CSS selector, falling back to its name or id
+const frameElementScript = `
+var elem = null;
+try {
+	elem = document.querySelector(arguments[0]);
+} catch (e) {
+}
+return elem || document.getElementsByName(arguments[0])[0] || document.getElementById(arguments[0]);
+`
+
+// enterFrame switches into a child frame of the current one given by index, or by selector, name or id
+func enterFrame(ctx *Context, frame interface{}) error {
+	switch f := frame.(type) {
+	case float64:
+		return ctx.WebDriver.SwitchFrame(int(f))
+	case string:
+		raw, err := ctx.WebDriver.ExecuteScriptRaw(frameElementScript, []interface{}{f})
+		if err != nil {
+			return err
+		}
+		elem, err := ctx.WebDriver.DecodeElement(raw)
+		if err != nil {
+			return fmt.Errorf("no frame matching '%s'", f)
+		}
+		return ctx.WebDriver.SwitchFrame(elem)
+	default:
+		return validationErrorf("'frame' should be a selector, name or index")
+	}
+}
+
+func switchToDefaultContent(ctx *Context) error {
+	return ctx.WebDriver.SwitchFrame("")
+}
+
+// switchToWindow switches to the window with handle 'params.handle', or to the one at 'params.index'
+// in the driver's window order, where negative indices count from the end (-1 is the newest)
+func switchToWindow(ctx *Context, step Step) error {
+	if step.Params == nil {
+		return validationErrorf("switch_to_window action requires 'params'")
+	}
+	if value, ok := step.Params["handle"]; ok {
+		handle, ok := value.(string)
+		if !ok {
+			return validationErrorf("'handle' should be a string")
+		}
+		return ctx.WebDriver.SwitchWindow(handle)
+	}
+	value, ok := step.Params["index"]
+	if !ok {
+		return validationErrorf("switch_to_window action requires 'params.handle' or 'params.index'")
+	}
+	index, ok := value.(float64)
+	if !ok || index != math.Trunc(index) {
+		return validationErrorf("'index' should be an integer")
+	}
+	handles, err := ctx.WebDriver.WindowHandles()
+	if err != nil {
+		return err
+	}
+	i := int(index)
+	if i < 0 {
+		i += len(handles)
+	}
+	if i < 0 || i >= len(handles) {
+		return fmt.Errorf("window index %d out of range, %d windows are open", int(index), len(handles))
+	}
+	return ctx.WebDriver.SwitchWindow(handles[i])
+}
+
+// listWindows stores the handles of all open windows, comma-separated, in 'store_result_as'
+func listWindows(ctx *Context, step Step) error {
+	if step.StoreResultAs == "" {
+		return validationErrorf("list_windows action requires 'store_result_as'")
+	}
+	handles, err := ctx.WebDriver.WindowHandles()
+	if err != nil {
+		return err
+	}
+	ctx.Variables[step.StoreResultAs] = strings.Join(handles, ",")
+	return nil
+}
+
+// closeWindow closes the current window and switches to the last remaining one, so later steps
+// have a window to act on
+func closeWindow(ctx *Context) error {
+	if err := ctx.WebDriver.Close(); err != nil {
+		return err
+	}
+	handles, err := ctx.WebDriver.WindowHandles()
+	if err != nil {
+		return err
+	}
+	if len(handles) == 0 {
+		return nil
+	}
+	return ctx.WebDriver.SwitchWindow(handles[len(handles)-1])
+}
+
+// openNewWindow opens a new tab, or a window if 'params.type' is "window", and switches to it.
+// Its handle is stored in 'store_result_as' if set.
+func openNewWindow(ctx *Context, step Step) error {
+	windowType := "tab"
+	if value, ok := step.Params["type"]; ok {
+		if windowType, ok = value.(string); !ok || (windowType != "tab" && windowType != "window") {
+			return validationErrorf("'type' should be tab or window")
+		}
+	}
+	result, err := w3cCommand(ctx, http.MethodPost, "/window/new", map[string]string{"type": windowType})
+	if err != nil {
+		return err
+	}
+	var window struct {
+		Handle string `json:"handle"`
+	}
+	if err := json.Unmarshal(result, &window); err != nil || window.Handle == "" {
+		return fmt.Errorf("unexpected reply opening a new window: %s", result)
+	}
+	if step.StoreResultAs != "" {
+		ctx.Variables[step.StoreResultAs] = window.Handle
+	}
+	return ctx.WebDriver.SwitchWindow(window.Handle)
+}
+
+// setWindowSize resizes the current window to 'params.width' x 'params.height'
+func setWindowSize(ctx *Context, step Step) error {
+	width, err := windowDimension(step, "width")
+	if err != nil {
+		return err
+	}
+	height, err := windowDimension(step, "height")
+	if err != nil {
+		return err
+	}
+	return ctx.WebDriver.ResizeWindow("", width, height)
+}
+
+// windowDimension returns 'params.<name>' of a window resize step, which must be a positive integer
+func windowDimension(step Step, name string) (int, error) {
+	value, ok := step.Params[name]
+	if !ok {
+		return 0, validationErrorf("%s action requires 'params.%s'", step.Action, name)
+	}
+	size, ok := value.(float64)
+	if !ok || size < 1 || size != math.Trunc(size) {
+		return 0, validationErrorf("'%s' should be a positive integer", name)
+	}
+	return int(size), nil
+}
+
+// setWindowPosition moves the current window's top-left corner to 'params.x', 'params.y'.
+// The client library has no call for this, so the W3C window rect is set directly.
+func setWindowPosition(ctx *Context, step Step) error {
+	position := map[string]float64{}
+	for _, name := range []string{"x", "y"} {
+		value, ok := step.Params[name]
+		if !ok {
+			return validationErrorf("set_window_position action requires 'params.%s'", name)
+		}
+		coordinate, ok := value.(float64)
+		if !ok || coordinate != math.Trunc(coordinate) {
+			return validationErrorf("'%s' should be an integer", name)
+		}
+		position[name] = coordinate
+	}
+	_, err := w3cCommand(ctx, http.MethodPost, "/window/rect", position)
+	return err
+}
+
+func closeBrowser(ctx *Context) error {
+	return ctx.WebDriver.Close()
+}
+
+func quitBrowser(ctx *Context) error {
+	return ctx.WebDriver.Quit()
+}
+
+func getWindowCount(ctx *Context, step Step) error {
+	if step.StoreResultAs == "" {
+		return validationErrorf("get_window_count action requires 'store_result_as'")
+	}
+	handles, err := ctx.WebDriver.WindowHandles()
+	if err != nil {
+		return err
+	}
+	ctx.Variables[step.StoreResultAs] = strconv.Itoa(len(handles))
+	return nil
+}
+
+func assertWindowCount(ctx *Context, step Step) error {
+	expected, err := strconv.Atoi(step.ExpectedValue)
+	if err != nil {
+		return validationErrorf("assert_window_count action requires 'expected_value' as a number")
+	}
+	handles, err := ctx.WebDriver.WindowHandles()
+	if err != nil {
+		return err
+	}
+	if len(handles) != expected {
+		return fmt.Errorf("window count assertion failed: expected %d, got %d", expected, len(handles))
+	}
+	return nil
+}
+
+// waitForAlert polls until an alert, confirm or prompt dialog is open, storing its text in
+// 'store_result_as' if set
+func waitForAlert(ctx *Context, step Step) error {
+	endTime := time.Now().Add(time.Duration(step.Timeout) * time.Second)
+	for {
+		text, err := ctx.WebDriver.AlertText()
+		if err == nil {
+			if step.StoreResultAs != "" {
+				ctx.Variables[step.StoreResultAs] = text
+			}
+			return nil
+		}
+		if time.Now().After(endTime) {
+			return fmt.Errorf("no alert appeared after %d seconds", step.Timeout)
+		}
+		idle(ctx, 500*time.Millisecond)
+	}
+}
+
+// alertError replaces the driver's "no such alert" error with a clearer one
+func alertError(err error) error {
+	var seleniumErr *selenium.Error
+	if errors.As(err, &seleniumErr) && seleniumErr.Err == "no such alert" {
+		return errors.New("no alert is open")
+	}
+	return err
+}
+
+// getAlertText stores the message of the open alert, confirm or prompt dialog in 'store_result_as'
+func getAlertText(ctx *Context, step Step) error {
+	if step.StoreResultAs == "" {
+		return validationErrorf("get_alert_text action requires 'store_result_as'")
+	}
+	text, err := ctx.WebDriver.AlertText()
+	if err != nil {
+		return alertError(err)
+	}
+	ctx.Variables[step.StoreResultAs] = text
+	return nil
+}
+
+// answerPrompt types step.Text into an open window.prompt dialog and accepts it
+func answerPrompt(ctx *Context, step Step) error {
+	if err := ctx.WebDriver.SetAlertText(step.Text); err != nil {
+		return fmt.Errorf("failed to answer prompt: %v", alertError(err))
+	}
+	if err := ctx.WebDriver.AcceptAlert(); err != nil {
+		return fmt.Errorf("failed to accept prompt: %v", alertError(err))
+	}
+	return nil
+}
+
+func assertTitle(ctx *Context, step Step) error {
+	expected, err := expectedValues(step, false)
+	if err != nil {
+		return err
+	}
+	mode, err := matchMode(step, "equals")
+	if err != nil {
+		return err
+	}
+	title, err := ctx.WebDriver.Title()
+	if err != nil {
+		return err
+	}
+	matched, err := matchAny(mode, title, expected)
+	if err != nil {
+		return err
+	}
+	if !matched {
+		return fmt.Errorf("title assertion failed: expected %s (%s), got '%s'", describeExpected(expected), mode, title)
+	}
+	return nil
+}
+
+// getURL stores the current URL in 'store_result_as'
+func getURL(ctx *Context, step Step) error {
+	if step.StoreResultAs == "" {
+		return validationErrorf("get_url action requires 'store_result_as'")
+	}
+	currentURL, err := ctx.WebDriver.CurrentURL()
+	if err != nil {
+		return err
+	}
+	ctx.Variables[step.StoreResultAs] = currentURL
+	return nil
+}
+
+// assertURL compares the current URL with 'expected_value' (or any of 'params.expected_any')
+// using 'params.mode', "equals" by default
+func assertURL(ctx *Context, step Step) error {
+	expected, err := expectedValues(step, false)
+	if err != nil {
+		return err
+	}
+	mode, err := matchMode(step, "equals")
+	if err != nil {
+		return err
+	}
+	currentURL, err := ctx.WebDriver.CurrentURL()
+	if err != nil {
+		return err
+	}
+	matched, err := matchAny(mode, currentURL, expected)
+	if err != nil {
+		return err
+	}
+	if !matched {
+		return fmt.Errorf("url assertion failed: expected %s (%s), got '%s'", describeExpected(expected), mode, currentURL)
+	}
+	return nil
+}
+
+// assertText compares the element's text with 'expected_value' (or any of 'params.expected_any')
+// using 'params.mode', "equals" by default
+func assertText(ctx *Context, step Step) error {
+	expected, err := expectedValues(step, false)
+	if err != nil {
+		return err
+	}
+	mode, err := matchMode(step, "equals")
+	if err != nil {
+		return err
+	}
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	text, err := elementText(ctx, elem, step)
+	if err != nil {
+		return err
+	}
+	matched, err := matchAny(mode, text, expected)
+	if err != nil {
+		return err
+	}
+	if !matched {
+		message := fmt.Sprintf("text assertion failed for '%s' (%s):\n  expected: %s\n  actual:   '%s'", step.Selector, mode, describeExpected(expected), text)
+		if mode == "equals" && len(expected) == 1 {
+			message += fmt.Sprintf("\n  first difference at offset %d", firstDifference(expected[0], text))
+		}
+		return errors.New(message)
+	}
+	return nil
+}
+
+// firstDifference returns the byte offset of the first difference between a and b
+func firstDifference(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// assertNoFailedRequests fails if a network response with a 4xx or 5xx status was received since
+// the previous assert_no_failed_requests. URLs matching the 'params.ignore' regex are skipped. Chrome and Edge only.
+func assertNoFailedRequests(ctx *Context, step Step) error {
+	var ignore *regexp.Regexp
+	if step.Params != nil {
+		if value, ok := step.Params["ignore"]; ok {
+			pattern, ok := value.(string)
+			if !ok {
+				return validationErrorf("'ignore' should be a string")
+			}
+			var err error
+			if ignore, err = regexp.Compile(pattern); err != nil {
+				return validationErrorf("invalid 'ignore' regex: %v", err)
+			}
+		}
+	}
+	if _, err := performanceEvents(ctx); err != nil {
+		return err
+	}
+	requests := ctx.FailedRequests
+	ctx.FailedRequests = nil
+	var failures []string
+	for _, request := range requests {
+		if ignore != nil && ignore.MatchString(request.URL) {
+			continue
+		}
+		failures = append(failures, fmt.Sprintf("%d %s", request.Status, request.URL))
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d failed requests:\n  %s", len(failures), strings.Join(failures, "\n  "))
+	}
+	return nil
+}
+
+// assertRedirectChain navigates to step.URL and records the redirects of the main document from
+// the DevTools network events. The hops, as "status url" lines, are stored in 'store_result_as' if set.
+// The final URL is compared to 'expected_value' and, if given, the list of URLs visited, starting
+// with step.URL, to 'params.expected'.
+func assertRedirectChain(ctx *Context, step Step) error {
+	if step.URL == "" {
+		return validationErrorf("assert_redirect_chain action requires 'url'")
+	}
+	var expectedChain []string
+	if step.Params != nil {
+		if value, ok := step.Params["expected"]; ok {
+			list, ok := value.([]interface{})
+			if !ok {
+				return validationErrorf("'expected' should be an array of URLs")
+			}
+			for _, v := range list {
+				url, ok := v.(string)
+				if !ok {
+					return validationErrorf("'expected' should only contain strings")
+				}
+				expectedChain = append(expectedChain, url)
+			}
+		}
+	}
+	// Drain earlier events to only look at the ones from this navigation
+	if _, err := performanceEvents(ctx); err != nil {
+		return err
+	}
+	if err := ctx.WebDriver.Get(step.URL); err != nil {
+		return err
+	}
+	events, err := performanceEvents(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Redirects reuse the request id of the original document request
+	var documentRequest string
+	var hops, urls []string
+	for _, event := range events {
+		if event.Method != "Network.requestWillBeSent" {
+			continue
+		}
+		var params struct {
+			RequestID string `json:"requestId"`
+			Type      string `json:"type"`
+			Request   struct {
+				URL string `json:"url"`
+			} `json:"request"`
+			RedirectResponse *struct {
+				URL    string  `json:"url"`
+				Status float64 `json:"status"`
+			} `json:"redirectResponse"`
+		}
+		if err := json.Unmarshal(event.Params, &params); err != nil || params.Type != "Document" {
+			continue
+		}
+		if documentRequest == "" {
+			documentRequest = params.RequestID
+		}
+		if params.RequestID != documentRequest {
+			continue
+		}
+		if params.RedirectResponse != nil {
+			hops = append(hops, fmt.Sprintf("%d %s", int(params.RedirectResponse.Status), params.RedirectResponse.URL))
+		}
+		urls = append(urls, params.Request.URL)
+	}
+	finalURL, err := ctx.WebDriver.CurrentURL()
+	if err != nil {
+		return err
+	}
+	hops = append(hops, "final "+finalURL)
+	if step.StoreResultAs != "" {
+		ctx.Variables[step.StoreResultAs] = strings.Join(hops, "\n")
+	}
+
+	if step.ExpectedValue != "" && finalURL != step.ExpectedValue {
+		return fmt.Errorf("redirect chain ended at '%s', expected '%s':\n  %s", finalURL, step.ExpectedValue, strings.Join(hops, "\n  "))
+	}
+	if expectedChain != nil && strings.Join(urls, "\n") != strings.Join(expectedChain, "\n") {
+		return fmt.Errorf("redirect chain mismatch: expected ['%s'], got ['%s']", strings.Join(expectedChain, "', '"), strings.Join(urls, "', '"))
+	}
+	return nil
+}
+
+// tableRowsSelector and tableCellsSelector select the rows of a table and the cells of a row
+// without descending into nested tables
+const (
+	tableRowsSelector  = ":scope > thead > tr, :scope > tbody > tr, :scope > tfoot > tr, :scope > tr"
+	tableCellsSelector = ":scope > td, :scope > th"
+)
+
+// assertTable checks a table's structure: 'params.rows' is the expected number of rows,
+// 'params.columns' the expected number of cells in the widest row, and 'params.row'/'params.col'
+// (0-based, header rows included) select a cell that must match 'expected_value' or
+// 'params.expected_any' using 'params.mode' (default contains). Rows of nested tables don't count.
+func assertTable(ctx *Context, step Step) error {
+	if step.Params == nil {
+		return validationErrorf("assert_table action requires 'params'")
+	}
+	intParam := func(key string) (int, bool, error) {
+		value, ok := step.Params[key]
+		if !ok {
+			return 0, false, nil
+		}
+		number, ok := value.(float64)
+		if !ok || number < 0 {
+			return 0, false, validationErrorf("'%s' should be a non-negative number", key)
+		}
+		return int(number), true, nil
+	}
+	expectedRows, checkRows, err := intParam("rows")
+	if err != nil {
+		return err
+	}
+	expectedCols, checkCols, err := intParam("columns")
+	if err != nil {
+		return err
+	}
+	row, hasRow, err := intParam("row")
+	if err != nil {
+		return err
+	}
+	col, hasCol, err := intParam("col")
+	if err != nil {
+		return err
+	}
+	if hasRow != hasCol {
+		return validationErrorf("assert_table action requires both 'params.row' and 'params.col' to check a cell")
+	}
+	if !checkRows && !checkCols && !hasRow {
+		return validationErrorf("assert_table action requires 'params.rows', 'params.columns' or 'params.row'/'params.col'")
+	}
+	var expected []string
+	var mode string
+	if hasRow {
+		if expected, err = expectedValues(step, false); err != nil {
+			return err
+		}
+		if mode, err = matchMode(step, "contains"); err != nil {
+			return err
+		}
+	}
+
+	table, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	rows, err := table.FindElements(selenium.ByCSSSelector, tableRowsSelector)
+	if err != nil {
+		return err
+	}
+	if checkRows && len(rows) != expectedRows {
+		return fmt.Errorf("table '%s' has %d rows, expected %d", step.Selector, len(rows), expectedRows)
+	}
+	if checkCols {
+		cols := 0
+		for _, r := range rows {
+			cells, err := r.FindElements(selenium.ByCSSSelector, tableCellsSelector)
+			if err != nil {
+				return err
+			}
+			if len(cells) > cols {
+				cols = len(cells)
+			}
+		}
+		if cols != expectedCols {
+			return fmt.Errorf("table '%s' has %d columns, expected %d", step.Selector, cols, expectedCols)
+		}
+	}
+	if hasRow {
+		if row >= len(rows) {
+			return fmt.Errorf("table '%s' has no row %d (%d rows)", step.Selector, row, len(rows))
+		}
+		cells, err := rows[row].FindElements(selenium.ByCSSSelector, tableCellsSelector)
+		if err != nil {
+			return err
+		}
+		if col >= len(cells) {
+			return fmt.Errorf("row %d of table '%s' has no column %d (%d columns)", row, step.Selector, col, len(cells))
+		}
+		text, err := cells[col].Text()
+		if err != nil {
+			return err
+		}
+		matched, err := matchAny(mode, text, expected)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			return fmt.Errorf("cell [%d][%d] of table '%s' is '%s', expected %s (%s)", row, col, step.Selector, text, describeExpected(expected), mode)
+		}
+	}
+	return nil
+}
+
+// assertOrder checks the elements matching 'params.selectors' appear top to bottom in that order,
+// comparing the Y coordinate of their top edges
+func assertOrder(ctx *Context, step Step) error {
+	if step.Params == nil {
+		return validationErrorf("assert_order action requires 'params'")
+	}
+	list, ok := step.Params["selectors"].([]interface{})
+	if !ok || len(list) < 2 {
+		return validationErrorf("assert_order action requires 'params.selectors' with at least two selectors")
+	}
+	var prevSelector string
+	var prev *selenium.Point
+	for _, v := range list {
+		selector, ok := v.(string)
+		if !ok {
+			return validationErrorf("'selectors' should only contain strings")
+		}
+		elem, err := findElement(ctx, step.By, selector, step.Timeout)
+		if err != nil {
+			return err
+		}
+		location, err := elem.Location()
+		if err != nil {
+			return err
+		}
+		if prev != nil && location.Y < prev.Y {
+			return fmt.Errorf("order assertion failed: '%s' (y=%d) is above '%s' (y=%d)", selector, location.Y, prevSelector, prev.Y)
+		}
+		prevSelector, prev = selector, location
+	}
+	return nil
+}
+
+// assertContrast checks the WCAG contrast ratio between the element's text color and its background
+// meets 'params.level' ("AA", the default, or "AAA"), using the large text thresholds when
+// 'params.large_text' is true. A transparent background is taken from the nearest ancestor that has one.
+func assertContrast(ctx *Context, step Step) error {
+	level, largeText := "AA", false
+	if step.Params != nil {
+		if value, ok := step.Params["level"]; ok {
+			if level, ok = value.(string); !ok {
+				return validationErrorf("'level' should be a string")
+			}
+		}
+		if value, ok := step.Params["large_text"]; ok {
+			if largeText, ok = value.(bool); !ok {
+				return validationErrorf("'large_text' should be a boolean")
+			}
+		}
+	}
+	thresholds := map[string][2]float64{"AA": {4.5, 3}, "AAA": {7, 4.5}}
+	threshold, ok := thresholds[strings.ToUpper(level)]
+	if !ok {
+		return validationErrorf("invalid level '%s': expected AA or AAA", level)
+	}
+	required := threshold[0]
+	if largeText {
+		required = threshold[1]
+	}
+
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	fgValue, err := elem.CSSProperty("color")
+	if err != nil {
+		return err
+	}
+	bgValue, err := elem.CSSProperty("background-color")
+	if err != nil {
+		return err
+	}
+	fg, err := parseCSSColor(fgValue)
+	if err != nil {
+		return err
+	}
+	bg, err := parseCSSColor(bgValue)
+	if err != nil {
+		return err
+	}
+	if bg[3] == 0 {
+		script := `
+		for (var el = arguments[0].parentElement; el; el = el.parentElement) {
+			var color = getComputedStyle(el).backgroundColor;
+			if (color !== 'transparent' && !/^rgba\(.*,\s*0\)$/.test(color)) {
+				return color;
+			}
+		}
+		return 'rgb(255, 255, 255)';
+		`
+		result, err := ctx.WebDriver.ExecuteScript(script, []interface{}{elem})
+		if err != nil {
+			return err
+		}
+		if bgValue, ok = result.(string); !ok {
+			return fmt.Errorf("unexpected background color: %v", result)
+		}
+		if bg, err = parseCSSColor(bgValue); err != nil {
+			return err
+		}
+	}
+
+	ratio := contrastRatio(blendColor(fg, bg), bg)
+	if ratio < required {
+		return fmt.Errorf("contrast of '%s' is %.2f:1 (color %s on %s), %s requires %.1f:1", step.Selector, ratio, fgValue, bgValue, strings.ToUpper(level), required)
+	}
+	return nil
+}
+
+// assertInViewport checks the element lies entirely within the visible viewport, or overlaps it at
+// all when 'params.partial' is true
+func assertInViewport(ctx *Context, step Step) error {
+	partial := false
+	if step.Params != nil {
+		if value, ok := step.Params["partial"]; ok {
+			if partial, ok = value.(bool); !ok {
+				return validationErrorf("'partial' should be a boolean")
+			}
+		}
+	}
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	script := `
+	var r = arguments[0].getBoundingClientRect();
+	var w = window.innerWidth || document.documentElement.clientWidth;
+	var h = window.innerHeight || document.documentElement.clientHeight;
+	var inside = arguments[1]
+		? r.bottom > 0 && r.right > 0 && r.top < h && r.left < w
+		: r.top >= 0 && r.left >= 0 && r.bottom <= h && r.right <= w;
+	return {inside: inside, top: r.top, left: r.left, width: r.width, height: r.height, viewportWidth: w, viewportHeight: h};
+	`
+	result, err := ctx.WebDriver.ExecuteScript(script, []interface{}{elem, partial})
+	if err != nil {
+		return err
+	}
+	rect, ok := result.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("unexpected result from viewport check: %v", result)
+	}
+	if inside, _ := rect["inside"].(bool); !inside {
+		return fmt.Errorf("element '%s' is not in the viewport: rect top=%v left=%v width=%v height=%v, viewport %vx%v",
+			step.Selector, rect["top"], rect["left"], rect["width"], rect["height"], rect["viewportWidth"], rect["viewportHeight"])
+	}
+	return nil
+}
+
+// assertAttribute compares an attribute of the element against 'expected_value' using
+// 'params.mode', which defaults to defaultMode
+func assertAttribute(ctx *Context, step Step, defaultMode string) error {
+	if step.Params == nil {
+		return validationErrorf("%s action requires 'params'", step.Action)
+	}
+	attr, ok := step.Params["attribute"]
+	if !ok {
+		return validationErrorf("%s action requires 'params.attribute'", step.Action)
+	}
+	attrStr, ok := attr.(string)
+	if !ok {
+		return validationErrorf("'attribute' should be a string")
+	}
+	expected, err := expectedValues(step, true)
+	if err != nil {
+		return err
+	}
+	mode, err := matchMode(step, defaultMode)
+	if err != nil {
+		return err
+	}
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+	value, err := elem.GetAttribute(attrStr)
+	if err != nil {
+		return err
+	}
+	matched, err := matchAny(mode, value, expected)
+	if err != nil {
+		return err
+	}
+	if !matched {
+		return fmt.Errorf("attribute '%s' of '%s' assertion failed: expected %s (%s), got '%s'", attrStr, step.Selector, describeExpected(expected), mode, value)
+	}
+	return nil
+}
+
+func assertElementPresent(ctx *Context, step Step) error {
+	if step.Selector == "" {
+		return validationErrorf("assert_element_present action requires 'selector'")
+	}
+	_, err := findStepElement(ctx, step)
+	if err != nil {
+		return fmt.Errorf("element '%s' not found", step.Selector)
+	}
+	return nil
+}
+
+// setAttribute sets attribute 'params.name' of the element to 'params.value', or removes it when
+// remove is set. This deliberately changes the DOM, e.g. to enable a disabled control.
+func setAttribute(ctx *Context, step Step, remove bool) error {
+	if step.Selector == "" {
+		return validationErrorf("%s action requires 'selector'", step.Action)
+	}
+	if step.Params == nil {
+		return validationErrorf("%s action requires 'params'", step.Action)
+	}
+	name, ok := step.Params["name"]
+	if !ok {
+		return validationErrorf("%s action requires 'params.name'", step.Action)
+	}
+	nameStr, ok := name.(string)
+	if !ok {
+		return validationErrorf("'name' should be a string")
+	}
+	valueStr := ""
+	if !remove {
+		value, ok := step.Params["value"]
+		if !ok {
+			return validationErrorf("%s action requires 'params.value'", step.Action)
+		}
+		if valueStr, ok = value.(string); !ok {
+			return validationErrorf("'value' should be a string")
+		}
+	}
+
+	elem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+
+	if remove {
+		if _, err := ctx.WebDriver.ExecuteScript("arguments[0].removeAttribute(arguments[1]);", []interface{}{elem, nameStr}); err != nil {
+			return fmt.Errorf("error removing attribute '%s': %v", nameStr, err)
+		}
+		fmt.Fprintf(console, "Removed attribute '%s' from '%s'\n", nameStr, step.Selector)
+		return nil
+	}
+	if _, err := ctx.WebDriver.ExecuteScript("arguments[0].setAttribute(arguments[1], arguments[2]);", []interface{}{elem, nameStr, valueStr}); err != nil {
+		return fmt.Errorf("error setting attribute '%s': %v", nameStr, err)
+	}
+	fmt.Fprintf(console, "Set attribute '%s' of '%s' to '%s'\n", nameStr, step.Selector, valueStr)
+	return nil
+}
+
+// brokenImagesScript returns the sources of images that finished loading without any pixels
+const brokenImagesScript = `
+var broken = [];
+document.querySelectorAll('img').forEach(function (img) {
+	if (img.complete && img.naturalWidth === 0) {
+		broken.push(img.currentSrc || img.src || img.outerHTML);
+	}
+});
+return broken;
+`
+
+// assertNoBrokenImages fails listing the sources of all images on the page that failed to load
+func assertNoBrokenImages(ctx *Context, step Step) error {
+	result, err := ctx.WebDriver.ExecuteScript(brokenImagesScript, nil)
+	if err != nil {
+		return err
+	}
+	broken, _ := result.([]interface{})
+	if len(broken) == 0 {
+		return nil
+	}
+	sources := make([]string, len(broken))
+	for i, src := range broken {
+		sources[i] = fmt.Sprintf("%v", src)
+	}
+	return fmt.Errorf("%d broken images: %s", len(sources), strings.Join(sources, ", "))
+}
+
+// assertElementCount checks that exactly 'expected_value' elements match the selector, waiting up
+// to the step's timeout for the count to settle on it
+func assertElementCount(ctx *Context, step Step) error {
+	expected, err := strconv.Atoi(step.ExpectedValue)
+	if err != nil {
+		return validationErrorf("assert_element_count action requires 'expected_value' as a number")
+	}
+	endTime := time.Now().Add(time.Duration(step.Timeout) * time.Second)
+	for {
+		elems, err := findStepElements(ctx, step)
+		if err != nil {
+			return err
+		}
+		if len(elems) == expected {
+			return nil
+		}
+		if time.Now().After(endTime) {
+			return fmt.Errorf("element count assertion failed: expected %d elements matching '%s', got %d", expected, step.Selector, len(elems))
+		}
+		idle(ctx, 500*time.Millisecond)
+	}
+}
+
+// assertContainsElement checks that 'params.child' matches an element inside the subtree of the
+// element matched by the step's selector
+func assertContainsElement(ctx *Context, step Step) error {
+	if step.Selector == "" {
+		return validationErrorf("assert_contains_element action requires 'selector'")
+	}
+	if step.Params == nil {
+		return validationErrorf("assert_contains_element action requires 'params'")
+	}
+	child, ok := step.Params["child"]
+	if !ok {
+		return validationErrorf("assert_contains_element action requires 'params.child'")
+	}
+	childSelector, ok := child.(string)
+	if !ok {
+		return validationErrorf("'child' should be a string")
+	}
+
+	// Find the parent element
+	parentElem, err := findStepElement(ctx, step)
+	if err != nil {
+		return err
+	}
+
+	// Find the child within the parent's subtree
+	if _, err := parentElem.FindElement(selenium.ByCSSSelector, childSelector); err != nil {
+		return fmt.Errorf("element '%s' not found inside '%s'", childSelector, step.Selector)
+	}
+	return nil
+}
+
+func printMessage(ctx *Context, step Step) error {
+	fmt.Fprintln(console, step.Message)
+	return nil
+}
+
+// setCookie adds a cookie from 'params.name' and 'params.value' plus the optional 'domain', 'path',
+// 'expiry' (unix seconds), 'secure', 'http_only' and 'same_site'. Like all params these have their
+// {{var}} placeholders expanded, so captured tokens can be planted as cookies.
+func setCookie(ctx *Context, step Step) error {
+	if step.Params == nil {
+		return validationErrorf("%s action requires 'params'", step.Action)
+	}
+	cookie := map[string]interface{}{}
+	for _, key := range []string{"name", "value", "domain", "path", "same_site"} {
+		value, ok := step.Params[key]
+		if !ok {
+			continue
+		}
+		str, ok := value.(string)
+		if !ok {
+			return validationErrorf("'%s' should be a string", key)
+		}
+		if key == "same_site" {
+			key = "sameSite"
+		}
+		cookie[key] = str
+	}
+	for _, key := range []string{"name", "value"} {
+		if _, ok := cookie[key]; !ok {
+			return validationErrorf("%s action requires 'params.%s'", step.Action, key)
+		}
+	}
+	for _, key := range []string{"secure", "http_only"} {
+		value, ok := step.Params[key]
+		if !ok {
+			continue
+		}
+		flag, ok := value.(bool)
+		if !ok {
+			return validationErrorf("'%s' should be a boolean", key)
+		}
+		if key == "http_only" {
+			key = "httpOnly"
+		}
+		cookie[key] = flag
+	}
+	if value, ok := step.Params["expiry"]; ok {
+		expiry, ok := value.(float64)
+		if !ok || expiry < 0 {
+			return validationErrorf("'expiry' should be a non-negative number")
+		}
+		cookie["expiry"] = int64(expiry)
 	}
+	_, err := w3cCommand(ctx, http.MethodPost, "/cookie", map[string]interface{}{"cookie": cookie})
+	return err
 }
 
-// Action Handlers
-
-func navigate(ctx *Context, step Step) error {
-	if step.URL == "" {
-		return errors.New("navigate action requires 'url'")
+// namedCookie returns the cookie named by 'params.name', failing if there is no such cookie
+func namedCookie(ctx *Context, step Step) (selenium.Cookie, error) {
+	if step.Params == nil {
+		return selenium.Cookie{}, validationErrorf("%s action requires 'params'", step.Action)
+	}
+	name, ok := step.Params["name"].(string)
+	if !ok {
+		return selenium.Cookie{}, validationErrorf("%s action requires 'params.name' as a string", step.Action)
+	}
+	cookies, err := ctx.WebDriver.GetCookies()
+	if err != nil {
+		return selenium.Cookie{}, err
+	}
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return cookie, nil
+		}
 	}
-	return ctx.WebDriver.Get(step.URL)
+	return selenium.Cookie{}, fmt.Errorf("cookie '%s' not found", name)
 }
 
-func click(ctx *Context, step Step) error {
-	elem, err := findElement(ctx, step.Selector, step.Timeout)
+// getCookie stores the value of the cookie 'params.name' in 'store_result_as'
+func getCookie(ctx *Context, step Step) error {
+	if step.StoreResultAs == "" {
+		return validationErrorf("get_cookie action requires 'store_result_as'")
+	}
+	cookie, err := namedCookie(ctx, step)
 	if err != nil {
 		return err
 	}
-	return elem.Click()
+	ctx.Variables[step.StoreResultAs] = cookie.Value
+	return nil
 }
 
-func doubleClick(ctx *Context, step Step) error {
-	_, err := findElement(ctx, step.Selector, step.Timeout)
+// deleteCookie deletes the cookie 'params.name', failing if it doesn't exist
+func deleteCookie(ctx *Context, step Step) error {
+	cookie, err := namedCookie(ctx, step)
 	if err != nil {
 		return err
 	}
-	return ctx.WebDriver.DoubleClick()
+	return ctx.WebDriver.DeleteCookie(cookie.Name)
 }
 
-func rightClick(ctx *Context, step Step) error {
-	elem, err := findElement(ctx, step.Selector, step.Timeout)
+// injectOnNewDocument registers step.Script to run before any page script in every document loaded
+// afterwards, including reloads and frames, for the rest of the session. It does not affect the
+// current page. The DevTools script identifier is stored in 'store_result_as' if set. Chrome and
+// Edge only.
+func injectOnNewDocument(ctx *Context, step Step) error {
+	if step.Script == "" {
+		return validationErrorf("inject_on_new_document action requires 'script'")
+	}
+	result, err := devToolsCommand(ctx, "Page.addScriptToEvaluateOnNewDocument", map[string]interface{}{"source": step.Script})
 	if err != nil {
 		return err
 	}
-	// Perform right click via JavaScript
-	script := "var evt = new MouseEvent('contextmenu', { bubbles: true, cancelable: true, view: window }); arguments[0].dispatchEvent(evt);"
-	_, err = ctx.WebDriver.ExecuteScript(script, []interface{}{elem})
-	return err
+	if step.StoreResultAs != "" {
+		var reply struct {
+			Identifier string `json:"identifier"`
+		}
+		if err := json.Unmarshal(result, &reply); err != nil {
+			return fmt.Errorf("unexpected reply from DevTools: %v", err)
+		}
+		ctx.Variables[step.StoreResultAs] = reply.Identifier
+	}
+	return nil
 }
 
-func enterText(ctx *Context, step Step) error {
-	elem, err := findElement(ctx, step.Selector, step.Timeout)
+// getAccessibilityNode stores the computed accessible role and name of the element matching the
+// CSS selector as '<store_result_as>_role' and '<store_result_as>_name'. Chrome and Edge only; it relies on
+// the experimental DevTools Accessibility domain, which may change between Chrome versions.
+func getAccessibilityNode(ctx *Context, step Step) error {
+	if step.StoreResultAs == "" {
+		return validationErrorf("get_accessibility_node action requires 'store_result_as'")
+	}
+	if err := requireCSSSelector(step); err != nil {
+		return err
+	}
+	if _, err := findElement(ctx, "", step.Selector, step.Timeout); err != nil {
+		return err
+	}
+	selector, err := json.Marshal(step.Selector)
+	if err != nil {
+		return err
+	}
+	result, err := devToolsCommand(ctx, "Runtime.evaluate", map[string]interface{}{
+		"expression": fmt.Sprintf("document.querySelector(%s)", selector),
+	})
+	if err != nil {
+		return err
+	}
+	var evaluated struct {
+		Result struct {
+			ObjectID string `json:"objectId"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(result, &evaluated); err != nil || evaluated.Result.ObjectID == "" {
+		return fmt.Errorf("element '%s' not found in the DevTools document", step.Selector)
+	}
+	result, err = devToolsCommand(ctx, "Accessibility.getPartialAXTree", map[string]interface{}{
+		"objectId":       evaluated.Result.ObjectID,
+		"fetchRelatives": false,
+	})
 	if err != nil {
 		return err
 	}
-	return elem.SendKeys(step.Text)
+	var tree struct {
+		Nodes []struct {
+			Ignored bool `json:"ignored"`
+			Role    struct {
+				Value interface{} `json:"value"`
+			} `json:"role"`
+			Name struct {
+				Value interface{} `json:"value"`
+			} `json:"name"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(result, &tree); err != nil {
+		return fmt.Errorf("unexpected accessibility tree: %v", err)
+	}
+	if len(tree.Nodes) == 0 {
+		return fmt.Errorf("no accessibility node for '%s'", step.Selector)
+	}
+	node := tree.Nodes[0]
+	if node.Ignored {
+		log.Printf("Element '%s' is ignored by the accessibility tree", step.Selector)
+	}
+	role, name := "", ""
+	if node.Role.Value != nil {
+		role = fmt.Sprintf("%v", node.Role.Value)
+	}
+	if node.Name.Value != nil {
+		name = fmt.Sprintf("%v", node.Name.Value)
+	}
+	ctx.Variables[step.StoreResultAs+"_role"] = role
+	ctx.Variables[step.StoreResultAs+"_name"] = name
+	return nil
 }
 
-func clearText(ctx *Context, step Step) error {
-	elem, err := findElement(ctx, step.Selector, step.Timeout)
+// pageOrigin returns the origin of the current page, e.g. "https://example.com:8080"
+func pageOrigin(ctx *Context) (string, error) {
+	currentURL, err := ctx.WebDriver.CurrentURL()
 	if err != nil {
-		return err
+		return "", err
 	}
-	return elem.Clear()
+	parsed, err := url.Parse(currentURL)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Scheme + "://" + parsed.Host, nil
 }
 
-func selectOption(ctx *Context, step Step) error {
+// permissionStates are the settings accepted by set_permission's 'params.state'
+var permissionStates = map[string]bool{"granted": true, "denied": true, "prompt": true}
+
+// setPermission sets browser permission 'params.name' to 'params.state' ("granted", "denied" or
+// "prompt", default "granted") for 'params.origin', or the current page's origin if omitted.
+// Chrome and Edge only. Names are those of the Permissions API, e.g. "notifications", "geolocation",
+// "camera", "microphone", "clipboard-read", "clipboard-write", "midi" and "persistent-storage".
+func setPermission(ctx *Context, step Step) error {
 	if step.Params == nil {
-		return errors.New("select_option action requires 'params'")
+		return validationErrorf("set_permission action requires 'params'")
 	}
-	value, ok := step.Params["value"]
+	name, ok := step.Params["name"].(string)
 	if !ok {
-		return errors.New("select_option action requires 'params.value'")
+		return validationErrorf("set_permission action requires 'params.name' as a string")
 	}
-	valueStr, ok := value.(string)
-	if !ok {
-		return errors.New("'value' should be a string")
+	state := "granted"
+	if value, ok := step.Params["state"]; ok {
+		if state, ok = value.(string); !ok || !permissionStates[state] {
+			return validationErrorf("'state' should be one of granted, denied or prompt")
+		}
+	}
+	origin := ""
+	if value, ok := step.Params["origin"]; ok {
+		if origin, ok = value.(string); !ok {
+			return validationErrorf("'origin' should be a string")
+		}
+	}
+	if !chromium(ctx.Browser) {
+		return fmt.Errorf("set_permission is only supported in chrome and edge, not %s", ctx.Browser)
 	}
+	if origin == "" {
+		var err error
+		if origin, err = pageOrigin(ctx); err != nil {
+			return err
+		}
+	}
+	_, err := devToolsCommand(ctx, "Browser.setPermission", map[string]interface{}{
+		"permission": map[string]string{"name": name},
+		"setting":    state,
+		"origin":     origin,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set permission '%s' to %s: %v", name, state, err)
+	}
+	return nil
+}
 
-	// Find the select element
-	selectElem, err := findElement(ctx, step.Selector, step.Timeout)
+// getClipboard stores the clipboard's text in 'store_result_as', e.g. after clicking a copy button.
+// Chrome and Edge only: reading the clipboard needs the clipboard-read permission, which is granted
+// to the current page's origin through DevTools before navigator.clipboard.readText is called.
+func getClipboard(ctx *Context, step Step) error {
+	if step.StoreResultAs == "" {
+		return validationErrorf("get_clipboard action requires 'store_result_as'")
+	}
+	if !chromium(ctx.Browser) {
+		return fmt.Errorf("get_clipboard is only supported in chrome and edge, not %s", ctx.Browser)
+	}
+	origin, err := pageOrigin(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := devToolsCommand(ctx, "Browser.grantPermissions", map[string]interface{}{
+		"origin":      origin,
+		"permissions": []string{"clipboardReadWrite", "clipboardSanitizedWrite"},
+	}); err != nil {
+		return fmt.Errorf("failed to grant clipboard permission: %v", err)
+	}
+	result, err := devToolsCommand(ctx, "Runtime.evaluate", map[string]interface{}{
+		"expression":    "window.focus(), navigator.clipboard.readText()",
+		"awaitPromise":  true,
+		"returnByValue": true,
+		"userGesture":   true,
+	})
 	if err != nil {
 		return err
 	}
+	var evaluated struct {
+		Result struct {
+			Value interface{} `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Exception struct {
+				Description string `json:"description"`
+			} `json:"exception"`
+		} `json:"exceptionDetails"`
+	}
+	if err := json.Unmarshal(result, &evaluated); err != nil {
+		return fmt.Errorf("unexpected reply from DevTools: %v", err)
+	}
+	if evaluated.ExceptionDetails != nil {
+		return fmt.Errorf("failed to read clipboard: %s", evaluated.ExceptionDetails.Exception.Description)
+	}
+	text, ok := evaluated.Result.Value.(string)
+	if !ok {
+		return errors.New("clipboard does not hold text")
+	}
+	ctx.Variables[step.StoreResultAs] = text
+	return nil
+}
 
-	// Find the option with the specified value
-	optionSelector := fmt.Sprintf("option[value='%s']", valueStr)
-	optionElem, err := selectElem.FindElement(selenium.ByCSSSelector, optionSelector)
+// Helper Functions
+
+// chromium reports whether browser is Chromium based, so it speaks the DevTools protocol and
+// keeps browser and performance logs
+func chromium(browser string) bool {
+	return browser == "chrome" || browser == "edge"
+}
+
+// devToolsCommand runs a DevTools protocol command through chromedriver, or msedgedriver, and
+// returns its result
+func devToolsCommand(ctx *Context, cmd string, params map[string]interface{}) (json.RawMessage, error) {
+	if !chromium(ctx.Browser) {
+		return nil, fmt.Errorf("%s requires the DevTools protocol, which is only available in chrome and edge, not %s", cmd, ctx.Browser)
+	}
+	// The drivers expose the same command under their vendor prefix
+	vendor := "goog"
+	if ctx.Browser == "edge" {
+		vendor = "ms"
+	}
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	return w3cCommand(ctx, http.MethodPost, "/"+vendor+"/cdp/execute", map[string]interface{}{"cmd": cmd, "params": params})
+}
+
+// nestedStepParams are the params holding nested steps. They are interpolated when each nested step
+// runs rather than up front, so they see variables set by earlier nested steps and loop iterations.
+var nestedStepParams = map[string]bool{"then": true, "steps": true, "until": true}
+
+// interpolateParams returns a copy of params with {{name}} placeholders expanded in all strings,
+// recursing into nested maps and arrays. The original map is left untouched so repeated runs of a
+// step, e.g. by retries, interpolate afresh.
+func interpolateParams(ctx *Context, params map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		if nestedStepParams[key] {
+			result[key] = value
+			continue
+		}
+		result[key] = interpolateValue(ctx, value)
+	}
+	return result
+}
+
+// interpolateValue expands placeholders in value if it is a string, or in its elements if it is a
+// map or array
+func interpolateValue(ctx *Context, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return interpolate(ctx, v)
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			result[key] = interpolateValue(ctx, elem)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, elem := range v {
+			result[i] = interpolateValue(ctx, elem)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// placeholderPattern matches {{name}} placeholders, optionally escaped with a leading backslash
+var placeholderPattern = regexp.MustCompile(`\\?\{\{([^{}]+)\}\}`)
+
+// interpolate replaces {{name}} placeholders in s with the values of the matching variables.
+// Placeholders naming unknown variables are left untouched, and \{{name}} produces a literal
+// {{name}}. Substituted values are not expanded again.
+func interpolate(ctx *Context, s string) string {
+	return placeholderPattern.ReplaceAllStringFunc(s, func(placeholder string) string {
+		if strings.HasPrefix(placeholder, "\\") {
+			return placeholder[1:]
+		}
+		name := placeholder[2 : len(placeholder)-2]
+		if value, ok := ctx.Variables[name]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// loadVariables reads a JSON object of variables from filename. Non-string values are stored in
+// their JSON encoding so they can still be interpolated.
+func loadVariables(filename string) (map[string]string, error) {
+	data, err := os.ReadFile(filename)
 	if err != nil {
-		return fmt.Errorf("option with value '%s' not found", valueStr)
+		return nil, fmt.Errorf("error reading %s: %v", filename, err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", filename, err)
 	}
+	variables := make(map[string]string, len(raw))
+	for key, value := range raw {
+		var str string
+		if err := json.Unmarshal(value, &str); err != nil {
+			str = string(value)
+		}
+		variables[key] = str
+	}
+	return variables, nil
+}
 
-	// Click the option to select it
-	return optionElem.Click()
+// dumpVariables writes ctx.Variables as a JSON object to filename, doing nothing if filename is empty
+func dumpVariables(ctx *Context, filename string) {
+	if filename == "" {
+		return
+	}
+	data, err := json.MarshalIndent(ctx.Variables, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode variables: %v", err)
+		return
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		log.Printf("Failed to write variables to %s: %v", filename, err)
+	}
 }
 
-func deselectOption(ctx *Context, step Step) error {
+// Humanizer adds random pauses to interactions so their timing looks less mechanical. A nil
+// Humanizer doesn't pause.
+type Humanizer struct {
+	rng      *rand.Rand
+	min, max time.Duration
+}
+
+// NewHumanizer returns a Humanizer pausing between min and max, drawing from an RNG seeded with seed
+func NewHumanizer(min, max time.Duration, seed int64) *Humanizer {
+	if max < min {
+		min, max = max, min
+	}
+	return &Humanizer{rng: rand.New(rand.NewSource(seed)), min: min, max: max}
+}
+
+// Pause sleeps for a random duration within the configured bounds
+func (h *Humanizer) Pause() {
+	if h == nil {
+		return
+	}
+	delay := h.min
+	if h.max > h.min {
+		delay += time.Duration(h.rng.Int63n(int64(h.max - h.min)))
+	}
+	time.Sleep(delay)
+}
+
+// typeKeys sends keys to elem, one character at a time with pauses in between when humanizing
+func typeKeys(ctx *Context, elem selenium.WebElement, keys string) error {
+	if ctx.Humanizer == nil {
+		return elem.SendKeys(keys)
+	}
+	for _, r := range keys {
+		ctx.Humanizer.Pause()
+		if err := elem.SendKeys(string(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// specialKeys maps key names, upper-cased and without underscores, to their WebDriver key codes
+var specialKeys = map[string]string{
+	"ENTER":      selenium.EnterKey,
+	"RETURN":     selenium.ReturnKey,
+	"TAB":        selenium.TabKey,
+	"BACKSPACE":  selenium.BackspaceKey,
+	"DELETE":     selenium.DeleteKey,
+	"ESCAPE":     selenium.EscapeKey,
+	"ESC":        selenium.EscapeKey,
+	"SPACE":      selenium.SpaceKey,
+	"UP":         selenium.UpArrowKey,
+	"DOWN":       selenium.DownArrowKey,
+	"LEFT":       selenium.LeftArrowKey,
+	"RIGHT":      selenium.RightArrowKey,
+	"ARROWUP":    selenium.UpArrowKey,
+	"ARROWDOWN":  selenium.DownArrowKey,
+	"ARROWLEFT":  selenium.LeftArrowKey,
+	"ARROWRIGHT": selenium.RightArrowKey,
+	"HOME":       selenium.HomeKey,
+	"END":        selenium.EndKey,
+	"PAGEUP":     selenium.PageUpKey,
+	"PAGEDOWN":   selenium.PageDownKey,
+	"INSERT":     selenium.InsertKey,
+	"SHIFT":      selenium.ShiftKey,
+	"CONTROL":    selenium.ControlKey,
+	"CTRL":       selenium.ControlKey,
+	"ALT":        selenium.AltKey,
+	"META":       selenium.MetaKey,
+	"F1":         selenium.F1Key,
+	"F2":         selenium.F2Key,
+	"F3":         selenium.F3Key,
+	"F4":         selenium.F4Key,
+	"F5":         selenium.F5Key,
+	"F6":         selenium.F6Key,
+	"F7":         selenium.F7Key,
+	"F8":         selenium.F8Key,
+	"F9":         selenium.F9Key,
+	"F10":        selenium.F10Key,
+	"F11":        selenium.F11Key,
+	"F12":        selenium.F12Key,
+}
+
+// lookupKey returns the key code for a key name such as "Enter", "page_down" or "ArrowDown"
+func lookupKey(name string) (string, bool) {
+	key, ok := specialKeys[strings.ToUpper(strings.ReplaceAll(name, "_", ""))]
+	return key, ok
+}
+
+var keyTokenPattern = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// expandKeyTokens replaces tokens like {ENTER} or {TAB} in text with their key codes. It returns
+// the keys to send and the literal text without the tokens. Unknown tokens are kept as typed.
+func expandKeyTokens(text string) (keys, literal string) {
+	keys = keyTokenPattern.ReplaceAllStringFunc(text, func(token string) string {
+		if key, ok := lookupKey(token[1 : len(token)-1]); ok {
+			return key
+		}
+		return token
+	})
+	literal = keyTokenPattern.ReplaceAllStringFunc(text, func(token string) string {
+		if _, ok := lookupKey(token[1 : len(token)-1]); ok {
+			return ""
+		}
+		return token
+	})
+	return keys, literal
+}
+
+// matchMode returns the comparison mode of an assertion from 'params.mode', or defaultMode if unset
+func matchMode(step Step, defaultMode string) (string, error) {
 	if step.Params == nil {
-		return errors.New("deselect_option action requires 'params'")
+		return defaultMode, nil
 	}
-	value, ok := step.Params["value"]
+	value, ok := step.Params["mode"]
 	if !ok {
-		return errors.New("deselect_option action requires 'params.value'")
+		return defaultMode, nil
 	}
-	valueStr, ok := value.(string)
+	mode, ok := value.(string)
 	if !ok {
-		return errors.New("'value' should be a string")
+		return "", validationErrorf("'mode' should be a string")
+	}
+	switch mode {
+	case "equals", "contains", "regex":
+		return mode, nil
+	default:
+		return "", validationErrorf("invalid mode '%s': expected equals, contains or regex", mode)
 	}
+}
 
-	// Find the select element
-	selectElem, err := findElement(ctx, step.Selector, step.Timeout)
-	if err != nil {
-		return err
+// expectedValues returns the values an assertion accepts: the 'params.expected_any' list when set,
+// otherwise 'expected_value'. An empty 'expected_value' is only accepted with allowEmpty, for
+// checks where the empty string is a meaningful expectation.
+func expectedValues(step Step, allowEmpty bool) ([]string, error) {
+	if step.Params != nil {
+		if value, ok := step.Params["expected_any"]; ok {
+			list, ok := value.([]interface{})
+			if !ok || len(list) == 0 {
+				return nil, validationErrorf("'expected_any' should be a non-empty array")
+			}
+			expected := make([]string, len(list))
+			for i, v := range list {
+				str, ok := v.(string)
+				if !ok {
+					return nil, validationErrorf("'expected_any' should only contain strings")
+				}
+				expected[i] = str
+			}
+			return expected, nil
+		}
 	}
+	if step.ExpectedValue == "" && !allowEmpty {
+		return nil, validationErrorf("%s action requires 'expected_value' or 'params.expected_any'", step.Action)
+	}
+	return []string{step.ExpectedValue}, nil
+}
 
-	// Find the option with the specified value
-	optionSelector := fmt.Sprintf("option[value='%s']", valueStr)
-	optionElem, err := selectElem.FindElement(selenium.ByCSSSelector, optionSelector)
+// matchAny reports whether actual matches at least one of expected under mode
+func matchAny(mode, actual string, expected []string) (bool, error) {
+	for _, candidate := range expected {
+		matched, err := matchValue(mode, actual, candidate)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+	return false, nil
+}
+
+// describeExpected formats the accepted values for failure messages
+func describeExpected(expected []string) string {
+	if len(expected) == 1 {
+		return fmt.Sprintf("'%s'", expected[0])
+	}
+	return fmt.Sprintf("one of ['%s']", strings.Join(expected, "', '"))
+}
+
+// matchValue reports whether actual matches expected under mode ("equals", "contains" or "regex")
+func matchValue(mode, actual, expected string) (bool, error) {
+	switch mode {
+	case "equals":
+		return actual == expected, nil
+	case "contains":
+		return strings.Contains(actual, expected), nil
+	case "regex":
+		re, err := regexp.Compile(expected)
+		if err != nil {
+			return false, validationErrorf("invalid regex '%s': %v", expected, err)
+		}
+		return re.MatchString(actual), nil
+	default:
+		return false, validationErrorf("invalid mode '%s'", mode)
+	}
+}
+
+// parseCSSColor parses a computed CSS color of the form rgb(r, g, b), rgba(r, g, b, a) or
+// rgb(r g b / a) into red, green, blue (0-255) and alpha (0-1)
+func parseCSSColor(value string) ([4]float64, error) {
+	color := [4]float64{0, 0, 0, 1}
+	value = strings.TrimSpace(value)
+	if value == "transparent" {
+		return [4]float64{}, nil
+	}
+	open, end := strings.Index(value, "("), strings.LastIndex(value, ")")
+	if open < 0 || end < open || !strings.HasPrefix(value, "rgb") {
+		return color, fmt.Errorf("unsupported color '%s'", value)
+	}
+	parts := strings.FieldsFunc(value[open+1:end], func(r rune) bool {
+		return r == ',' || r == '/' || r == ' '
+	})
+	if len(parts) != 3 && len(parts) != 4 {
+		return color, fmt.Errorf("unsupported color '%s'", value)
+	}
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(strings.TrimSuffix(part, "%"), 64)
+		if err != nil {
+			return color, fmt.Errorf("unsupported color '%s'", value)
+		}
+		if strings.HasSuffix(part, "%") {
+			if i == 3 {
+				number /= 100
+			} else {
+				number *= 255.0 / 100
+			}
+		}
+		color[i] = number
+	}
+	return color, nil
+}
+
+// blendColor composites a translucent foreground over an opaque background
+func blendColor(fg, bg [4]float64) [4]float64 {
+	alpha := fg[3]
+	return [4]float64{
+		fg[0]*alpha + bg[0]*(1-alpha),
+		fg[1]*alpha + bg[1]*(1-alpha),
+		fg[2]*alpha + bg[2]*(1-alpha),
+		1,
+	}
+}
+
+// relativeLuminance computes the WCAG 2 relative luminance of an sRGB color
+func relativeLuminance(color [4]float64) float64 {
+	var channels [3]float64
+	for i := range channels {
+		c := color[i] / 255
+		if c <= 0.03928 {
+			channels[i] = c / 12.92
+		} else {
+			channels[i] = math.Pow((c+0.055)/1.055, 2.4)
+		}
+	}
+	return 0.2126*channels[0] + 0.7152*channels[1] + 0.0722*channels[2]
+}
+
+// contrastRatio computes the WCAG contrast ratio between two colors, from 1 to 21
+func contrastRatio(a, b [4]float64) float64 {
+	la, lb := relativeLuminance(a), relativeLuminance(b)
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
+
+// elementText reads an element's text. By default this is the rendered text from Text(), which is
+// empty for hidden elements; 'params.source' set to "textContent" or "innerText" reads that DOM
+// property instead.
+func elementText(ctx *Context, elem selenium.WebElement, step Step) (string, error) {
+	source := "text"
+	if step.Params != nil {
+		if value, ok := step.Params["source"]; ok {
+			if source, ok = value.(string); !ok {
+				return "", validationErrorf("'source' should be a string")
+			}
+		}
+	}
+	switch source {
+	case "text":
+		return elem.Text()
+	case "textContent", "innerText":
+		result, err := ctx.WebDriver.ExecuteScript("return arguments[0][arguments[1]];", []interface{}{elem, source})
+		if err != nil {
+			return "", err
+		}
+		if result == nil {
+			return "", nil
+		}
+		return fmt.Sprintf("%v", result), nil
+	default:
+		return "", fmt.Errorf("invalid source '%s': expected text, textContent or innerText", source)
+	}
+}
+
+// startRecording captures a screenshot into dir every interval, named frame_000000.png onwards so
+// the frames can be assembled into a video, e.g. with ffmpeg -framerate 5 -i frame_%06d.png.
+// Frames are taken between steps and while a step waits or polls (see idle), so only a single
+// long driver command delays a frame. The returned function stops recording.
+func startRecording(ctx *Context, dir string, interval time.Duration) (func(), error) {
+	if interval <= 0 {
+		return nil, errors.New("record interval must be positive")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for frame := 0; ; {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			ctx.DriverLock.Lock()
+			err := saveScreenshot(ctx, filepath.Join(dir, fmt.Sprintf("frame_%06d.png", frame)))
+			if err != nil {
+				// Logged under the lock, the masking log writer reads the variables
+				log.Printf("Failed to record frame %d: %v", frame, err)
+			} else {
+				frame++
+			}
+			ctx.DriverLock.Unlock()
+		}
+	}()
+	return func() {
+		close(stop)
+		wg.Wait()
+	}, nil
+}
+
+// elementProperty reads a DOM property of elem. Strings are returned as is, other values JSON
+// encoded and null as the empty string.
+func elementProperty(ctx *Context, elem selenium.WebElement, name string) (string, error) {
+	ref, err := elementReference(elem)
 	if err != nil {
-		return fmt.Errorf("option with value '%s' not found", valueStr)
+		return "", err
+	}
+	path := fmt.Sprintf("/element/%s/property/%s", ref[webElementIdentifier], url.PathEscape(name))
+	value, err := w3cCommand(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return "", err
+	}
+	var str string
+	if err := json.Unmarshal(value, &str); err == nil {
+		return str, nil
 	}
+	if string(value) == "null" {
+		return "", nil
+	}
+	return string(value), nil
+}
 
-	// Deselect the option by clicking it (if multi-select)
-	// Note: The tebeka/selenium package does not provide a direct Deselect method
-	// We'll use JavaScript to deselect the option
-	script := "arguments[0].selected = false;"
-	_, err = ctx.WebDriver.ExecuteScript(script, []interface{}{optionElem})
+// saveScreenshot captures the current viewport and writes it to filename
+func saveScreenshot(ctx *Context, filename string) error {
+	png, err := ctx.WebDriver.Screenshot()
 	if err != nil {
-		return fmt.Errorf("failed to deselect option with value '%s': %v", valueStr, err)
+		return err
+	}
+	return os.WriteFile(filename, png, 0644)
+}
+
+// selectorStrategies maps the values of a step's 'by' field to selenium locator strategies
+var selectorStrategies = map[string]string{
+	"css":               selenium.ByCSSSelector,
+	"xpath":             selenium.ByXPATH,
+	"id":                selenium.ByID,
+	"name":              selenium.ByName,
+	"link_text":         selenium.ByLinkText,
+	"partial_link_text": selenium.ByPartialLinkText,
+	"tag_name":          selenium.ByTagName,
+	"class_name":        selenium.ByClassName,
+}
+
+// selectorStrategy returns the locator strategy for by, defaulting to CSS selectors
+func selectorStrategy(by string) (string, error) {
+	if by == "" {
+		return selenium.ByCSSSelector, nil
+	}
+	strategy, ok := selectorStrategies[by]
+	if !ok {
+		return "", validationErrorf("unknown selector strategy '%s', expected one of css, xpath, id, name, link_text, partial_link_text, tag_name or class_name", by)
 	}
-
-	return nil
+	return strategy, nil
 }
 
-func getText(ctx *Context, step Step) error {
-	if step.StoreResultAs == "" {
-		return errors.New("get_text action requires 'store_result_as'")
-	}
-	elem, err := findElement(ctx, step.Selector, step.Timeout)
+// requireCSSSelector rejects a 'by' other than css for steps whose selector is evaluated by a
+// script with querySelector, which only understands CSS
+func requireCSSSelector(step Step) error {
+	strategy, err := selectorStrategy(step.By)
 	if err != nil {
 		return err
 	}
-	text, err := elem.Text()
-	if err != nil {
-		return err
+	if strategy != selenium.ByCSSSelector {
+		return validationErrorf("%s action only supports CSS selectors, not 'by: %s'", step.Action, step.By)
 	}
-	ctx.Variables[step.StoreResultAs] = text
 	return nil
 }
 
-func getAttribute(ctx *Context, step Step) error {
-	if step.StoreResultAs == "" {
-		return errors.New("get_attribute action requires 'store_result_as'")
+// findElement locates an element using the provided selector and waits up to timeout seconds.
+// by names the locator strategy (see selectorStrategies).
+func findElement(ctx *Context, by, selector string, timeout int) (selenium.WebElement, error) {
+	if selector == "" {
+		return nil, validationErrorf("selector is required to find an element")
 	}
-	if step.Params == nil {
-		return errors.New("get_attribute action requires 'params'")
+	strategy, err := selectorStrategy(by)
+	if err != nil {
+		return nil, err
 	}
-	attr, ok := step.Params["attribute"]
-	if !ok {
-		return errors.New("get_attribute action requires 'params.attribute'")
+	waitTimeout := time.Duration(timeout) * time.Second
+	endTime := time.Now().Add(waitTimeout)
+
+	for {
+		elem, err := ctx.WebDriver.FindElement(strategy, selector)
+		if err == nil {
+			return elem, nil
+		}
+		if time.Now().After(endTime) {
+			return nil, notFoundError(ctx, fmt.Errorf("element with selector '%s' not found after %d seconds", selector, timeout))
+		}
+		idle(ctx, 500*time.Millisecond)
 	}
-	attrStr, ok := attr.(string)
-	if !ok {
-		return errors.New("'attribute' should be a string")
+}
+
+// consoleMatches drains the browser console log and returns the messages matching pattern
+func consoleMatches(ctx *Context, pattern *regexp.Regexp) ([]string, error) {
+	if !chromium(ctx.Browser) {
+		return nil, fmt.Errorf("console logs are only available in chrome and edge, not %s", ctx.Browser)
 	}
-	elem, err := findElement(ctx, step.Selector, step.Timeout)
+	messages, err := ctx.WebDriver.Log(seleniumlog.Browser)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to read browser log: %v", err)
 	}
-	value, err := elem.GetAttribute(attrStr)
-	if err != nil {
-		return err
+	var matches []string
+	for _, message := range messages {
+		if pattern.MatchString(message.Message) {
+			matches = append(matches, fmt.Sprintf("[%s] %s", message.Level, message.Message))
+		}
 	}
-	ctx.Variables[step.StoreResultAs] = value
-	return nil
+	return matches, nil
 }
 
-func waitDuration(step Step) error {
-	duration := time.Duration(step.WaitDuration) * time.Second
-	time.Sleep(duration)
-	return nil
+// devToolsEvent is a DevTools protocol event taken from the performance log
+type devToolsEvent struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
 }
 
-func takeScreenshot(ctx *Context, step Step) error {
-	filename := step.Filename
-	if filename == "" {
-		filename = fmt.Sprintf("screenshot_%d.png", time.Now().Unix())
+// failedRequest is a network response with a 4xx or 5xx status
+type failedRequest struct {
+	Status int
+	URL    string
+}
+
+// maxFailedRequests bounds ctx.FailedRequests; older ones are dropped first
+const maxFailedRequests = 1000
+
+// performanceEvents drains the performance log and returns the events read. Failed responses are
+// added to ctx.FailedRequests for assert_no_failed_requests.
+func performanceEvents(ctx *Context) ([]devToolsEvent, error) {
+	if !chromium(ctx.Browser) {
+		return nil, fmt.Errorf("network events are only available in chrome and edge, not %s", ctx.Browser)
 	}
-	png, err := ctx.WebDriver.Screenshot()
+	messages, err := ctx.WebDriver.Log(seleniumlog.Performance)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to read performance log: %v", err)
 	}
-	return os.WriteFile(filename, png, 0644)
+	var events []devToolsEvent
+	for _, message := range messages {
+		var entry struct {
+			Message devToolsEvent `json:"message"`
+		}
+		if err := json.Unmarshal([]byte(message.Message), &entry); err != nil {
+			continue
+		}
+		events = append(events, entry.Message)
+		if entry.Message.Method != "Network.responseReceived" {
+			continue
+		}
+		var params struct {
+			Response struct {
+				URL    string  `json:"url"`
+				Status float64 `json:"status"`
+			} `json:"response"`
+		}
+		if err := json.Unmarshal(entry.Message.Params, &params); err != nil || params.Response.Status < 400 {
+			continue
+		}
+		ctx.FailedRequests = append(ctx.FailedRequests, failedRequest{Status: int(params.Response.Status), URL: params.Response.URL})
+		if len(ctx.FailedRequests) > maxFailedRequests {
+			ctx.FailedRequests = ctx.FailedRequests[len(ctx.FailedRequests)-maxFailedRequests:]
+		}
+	}
+	return events, nil
 }
 
-func executeScript(ctx *Context, step Step) error {
-	if step.Script == "" {
-		return errors.New("execute_script action requires 'script'")
-	}
-	args := []interface{}{}
-	result, err := ctx.WebDriver.ExecuteScript(step.Script, args)
-	if err != nil {
+// notFoundError captures a screenshot into ctx.NotFoundScreenshotDir, if set, and mentions it in err
+func notFoundError(ctx *Context, err error) error {
+	if ctx.NotFoundScreenshotDir == "" {
 		return err
 	}
-	if step.StoreResultAs != "" {
-		ctx.Variables[step.StoreResultAs] = fmt.Sprintf("%v", result)
+	filename := filepath.Join(ctx.NotFoundScreenshotDir, fmt.Sprintf("not_found_%d.png", time.Now().UnixNano()))
+	if serr := saveScreenshot(ctx, filename); serr != nil {
+		return fmt.Errorf("%v (screenshot failed: %v)", err, serr)
 	}
-	return nil
+	return fmt.Errorf("%v (screenshot: %s)", err, filename)
 }
 
-func scroll(ctx *Context, step Step) error {
-	if step.Params == nil {
-		return errors.New("scroll action requires 'params'")
+// findStepElement locates the element a step targets, using a relative locator when 'params.relation' is set
+func findStepElement(ctx *Context, step Step) (selenium.WebElement, error) {
+	selector, err := stepSelector(step)
+	if err != nil {
+		return nil, err
 	}
-	direction, ok := step.Params["direction"]
+	step.Selector = selector
+	if step.Params != nil {
+		if _, ok := step.Params["relation"]; ok {
+			return findRelativeElement(ctx, step)
+		}
+		if value, ok := step.Params["index"]; ok {
+			index, ok := value.(float64)
+			if !ok || index < 0 {
+				return nil, validationErrorf("'index' should be a non-negative number")
+			}
+			return findIndexedElement(ctx, step, int(index))
+		}
+	}
+	return findElement(ctx, step.By, step.Selector, step.Timeout)
+}
+
+// stepSelector returns the step's selector, as a CSS id selector for the raw id in it with
+// 'params.escape'
+func stepSelector(step Step) (string, error) {
+	value, ok := step.Params["escape"]
 	if !ok {
-		return errors.New("scroll action requires 'params.direction'")
+		return step.Selector, nil
 	}
-	directionStr, ok := direction.(string)
+	escape, ok := value.(bool)
 	if !ok {
-		return errors.New("'direction' should be a string")
+		return "", validationErrorf("'escape' should be a boolean")
+	}
+	if !escape {
+		return step.Selector, nil
 	}
+	if step.By != "" && step.By != "css" {
+		return "", validationErrorf("'escape' only applies to CSS selectors, not 'by: %s'", step.By)
+	}
+	// The selector is a raw id, which may contain CSS metacharacters like '.' or ':'
+	return "#" + cssEscape(strings.TrimPrefix(step.Selector, "#")), nil
+}
 
-	var script string
-	switch strings.ToLower(directionStr) {
-	case "up":
-		script = "window.scrollBy(0, -100);"
-	case "down":
-		script = "window.scrollBy(0, 100);"
-	case "left":
-		script = "window.scrollBy(-100, 0);"
-	case "right":
-		script = "window.scrollBy(100, 0);"
-	default:
-		return errors.New("invalid scroll direction")
+// allElementsScript returns the elements matching a selector in document order, without
+// duplicates and, if arguments[1] is set, only those that are displayed
+const allElementsScript = `
+var visibleOnly = arguments[1], seen = new Set();
+return Array.prototype.filter.call(document.querySelectorAll(arguments[0]), function (elem) {
+	if (seen.has(elem)) {
+		return false;
+	}
+	seen.add(elem);
+	if (!visibleOnly) {
+		return true;
 	}
+	var style = window.getComputedStyle(elem);
+	return elem.getClientRects().length > 0 && style.visibility !== 'hidden' && style.display !== 'none';
+});
+`
 
-	_, err := ctx.WebDriver.ExecuteScript(script, nil)
-	return err
+// findStepElements returns all elements matching the step's selector in document order. With
+// 'params.visible_only' hidden matches, such as off-screen menu copies, are left out.
+// It doesn't wait: no match is an empty result, not an error.
+func findStepElements(ctx *Context, step Step) ([]selenium.WebElement, error) {
+	if step.Selector == "" {
+		return nil, validationErrorf("%s action requires 'selector'", step.Action)
+	}
+	selector, err := stepSelector(step)
+	if err != nil {
+		return nil, err
+	}
+	return findAllElements(ctx, step, selector)
 }
 
-func hover(ctx *Context, step Step) error {
-	elem, err := findElement(ctx, step.Selector, step.Timeout)
+// findAllElements does the work of findStepElements for the already escaped selector
+func findAllElements(ctx *Context, step Step, selector string) ([]selenium.WebElement, error) {
+	visibleOnly := false
+	if value, ok := step.Params["visible_only"]; ok {
+		if visibleOnly, ok = value.(bool); !ok {
+			return nil, validationErrorf("'visible_only' should be a boolean")
+		}
+	}
+	strategy, err := selectorStrategy(step.By)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if strategy != selenium.ByCSSSelector {
+		// Other strategies already return matches in document order
+		elems, err := ctx.WebDriver.FindElements(strategy, selector)
+		if err != nil || !visibleOnly {
+			return elems, err
+		}
+		var visible []selenium.WebElement
+		for _, elem := range elems {
+			if displayed, err := elem.IsDisplayed(); err == nil && displayed {
+				visible = append(visible, elem)
+			}
+		}
+		return visible, nil
+	}
+	raw, err := ctx.WebDriver.ExecuteScriptRaw(allElementsScript, []interface{}{selector, visibleOnly})
+	if err != nil {
+		return nil, err
 	}
-	return elem.MoveTo(0, 0)
+	return ctx.WebDriver.DecodeElements(raw)
 }
 
-func dragAndDrop(ctx *Context, step Step) error {
-	if step.Params == nil {
-		return errors.New("drag_and_drop action requires 'params'")
+// findIndexedElement waits until at least index+1 elements match and returns the one at index
+func findIndexedElement(ctx *Context, step Step, index int) (selenium.WebElement, error) {
+	endTime := time.Now().Add(time.Duration(step.Timeout) * time.Second)
+	for {
+		// findStepElement already escaped the selector
+		elems, err := findAllElements(ctx, step, step.Selector)
+		if err != nil {
+			return nil, err
+		}
+		if index < len(elems) {
+			return elems[index], nil
+		}
+		if time.Now().After(endTime) {
+			return nil, notFoundError(ctx, fmt.Errorf("no element at index %d of '%s' after %d seconds, %d matched", index, step.Selector, step.Timeout, len(elems)))
+		}
+		idle(ctx, 500*time.Millisecond)
 	}
-	sourceSelector, ok := step.Params["source_selector"]
-	if !ok {
-		return errors.New("drag_and_drop action requires 'params.source_selector'")
+}
+
+// cssEscape escapes an identifier for use in a CSS selector, following the CSS.escape() algorithm
+func cssEscape(ident string) string {
+	var sb strings.Builder
+	runes := []rune(ident)
+	for i, r := range runes {
+		switch {
+		case r == 0:
+			sb.WriteRune('\uFFFD')
+		case (r >= 0x1 && r <= 0x1f) || r == 0x7f,
+			i == 0 && r >= '0' && r <= '9',
+			i == 1 && r >= '0' && r <= '9' && runes[0] == '-':
+			fmt.Fprintf(&sb, "\\%x ", r)
+		case i == 0 && r == '-' && len(runes) == 1:
+			sb.WriteString("\\-")
+		case r >= 0x80 || r == '-' || r == '_' ||
+			(r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		}
 	}
-	targetSelector, ok := step.Params["target_selector"]
+	return sb.String()
+}
+
+// relativeLocatorScript picks the candidate matching arguments[1] closest to the anchor element
+// arguments[0] that satisfies the relation arguments[2]. For "near", arguments[3] is the maximum
+// gap in pixels between the two bounding boxes.
+const relativeLocatorScript = `
+var anchor = arguments[0], selector = arguments[1], relation = arguments[2], maxDistance = arguments[3];
+var a = anchor.getBoundingClientRect();
+var ax = a.left + a.width / 2, ay = a.top + a.height / 2;
+var best = null, bestDistance = Infinity;
+var candidates = document.querySelectorAll(selector);
+for (var i = 0; i < candidates.length; i++) {
+    var el = candidates[i];
+    if (el === anchor || el.contains(anchor) || anchor.contains(el)) {
+        continue;
+    }
+    var r = el.getBoundingClientRect();
+    if (r.width === 0 && r.height === 0) {
+        continue;
+    }
+    var matches = false;
+    switch (relation) {
+    case 'above': matches = r.bottom <= a.top; break;
+    case 'below': matches = r.top >= a.bottom; break;
+    case 'left_of': matches = r.right <= a.left; break;
+    case 'right_of': matches = r.left >= a.right; break;
+    case 'near':
+        var gx = Math.max(a.left - r.right, r.left - a.right, 0);
+        var gy = Math.max(a.top - r.bottom, r.top - a.bottom, 0);
+        matches = Math.sqrt(gx * gx + gy * gy) <= maxDistance;
+        break;
+    }
+    if (!matches) {
+        continue;
+    }
+    var dx = r.left + r.width / 2 - ax, dy = r.top + r.height / 2 - ay;
+    var distance = Math.sqrt(dx * dx + dy * dy);
+    if (distance < bestDistance) {
+        best = el;
+        bestDistance = distance;
+    }
+}
+return best;
+`
+
+// findRelativeElement finds the element matching step.Selector that is positioned relative to the
+// anchor element given by 'params.anchor', e.g. the input right of a label
+func findRelativeElement(ctx *Context, step Step) (selenium.WebElement, error) {
+	if step.Selector == "" {
+		return nil, validationErrorf("selector is required to find an element")
+	}
+	if step.By != "" && step.By != "css" {
+		return nil, validationErrorf("relative locators only support CSS selectors, not 'by: %s'", step.By)
+	}
+	relation, ok := step.Params["relation"].(string)
 	if !ok {
-		return errors.New("drag_and_drop action requires 'params.target_selector'")
+		return nil, validationErrorf("'relation' should be a string")
+	}
+	switch relation {
+	case "above", "below", "left_of", "right_of", "near":
+	default:
+		return nil, validationErrorf("invalid relation '%s': expected above, below, left_of, right_of or near", relation)
 	}
-	sourceSel, ok := sourceSelector.(string)
+	anchor, ok := step.Params["anchor"]
 	if !ok {
-		return errors.New("'source_selector' should be a string")
+		return nil, validationErrorf("relative locator requires 'params.anchor'")
 	}
-	targetSel, ok := targetSelector.(string)
+	anchorSel, ok := anchor.(string)
 	if !ok {
-		return errors.New("'target_selector' should be a string")
+		return nil, validationErrorf("'anchor' should be a string")
 	}
-
-	sourceElem, err := findElement(ctx, sourceSel, step.Timeout)
-	if err != nil {
-		return err
+	maxDistance := 50.0
+	if distance, ok := step.Params["distance"]; ok {
+		if maxDistance, ok = distance.(float64); !ok {
+			return nil, validationErrorf("'distance' should be a number")
+		}
 	}
-	targetElem, err := findElement(ctx, targetSel, step.Timeout)
+
+	anchorElem, err := findElement(ctx, "", anchorSel, step.Timeout)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Perform drag and drop via JavaScript
-	script := `
-	function simulateDragDrop(sourceNode, destinationNode) {
-	    var EVENT_TYPES = {
-	        DRAG_END: 'dragend',
-	        DRAG_START: 'dragstart',
-	        DROP: 'drop'
-	    }
+	endTime := time.Now().Add(time.Duration(step.Timeout) * time.Second)
+	for {
+		raw, err := ctx.WebDriver.ExecuteScriptRaw(relativeLocatorScript, []interface{}{anchorElem, step.Selector, relation, maxDistance})
+		if err != nil {
+			return nil, err
+		}
+		if elem, err := ctx.WebDriver.DecodeElement(raw); err == nil {
+			return elem, nil
+		}
+		if time.Now().After(endTime) {
+			return nil, notFoundError(ctx, fmt.Errorf("no element with selector '%s' found %s '%s' after %d seconds", step.Selector, relation, anchorSel, step.Timeout))
+		}
+		idle(ctx, 500*time.Millisecond)
+	}
+}
 
-	    function createCustomEvent(type) {
-	        var event = new CustomEvent("CustomEvent")
-	        event.initCustomEvent(type, true, true, null)
-	        event.dataTransfer = {
-	            data: {},
-	            setData: function(type, val) {
-	                this.data[type] = val
-	            },
-	            getData: function(type) {
-	                return this.data[type]
-	            }
-	        }
-	        return event
-	    }
+// newStepResult builds the result record of the step at idx that finished with err after duration
+func newStepResult(idx int, step Step, err error, duration time.Duration) StepResult {
+	result := StepResult{
+		Index:       idx,
+		Action:      step.Action,
+		Description: step.Description,
+		Location:    stepLocation(step),
+		Status:      "passed",
+		DurationMs:  duration.Milliseconds(),
+	}
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+	}
+	return result
+}
 
-	    function dispatchEvent(node, type, event) {
-	        if (node.dispatchEvent) {
-	            return node.dispatchEvent(event)
-	        }
-	        if (node.fireEvent) {
-	            return node.fireEvent("on" + type, event)
-	        }
-	    }
+// newSkippedResult builds the result record of a step that was not executed
+func newSkippedResult(idx int, step Step) StepResult {
+	return StepResult{
+		Index:       idx,
+		Action:      step.Action,
+		Description: step.Description,
+		Location:    stepLocation(step),
+		Status:      "skipped",
+	}
+}
 
-	    var dragStartEvent = createCustomEvent(EVENT_TYPES.DRAG_START)
-	    dispatchEvent(sourceNode, EVENT_TYPES.DRAG_START, dragStartEvent)
+// tagExpression selects steps by their tags. It is a disjunction of conjunctions of tag terms.
+type tagExpression [][]tagTerm
 
-	    var dropEvent = createCustomEvent(EVENT_TYPES.DROP)
-	    dropEvent.dataTransfer = dragStartEvent.dataTransfer
-	    dispatchEvent(destinationNode, EVENT_TYPES.DROP, dropEvent)
+// tagTerm requires a tag to be present, or absent if negated
+type tagTerm struct {
+	tag     string
+	negated bool
+}
 
-	    var dragEndEvent = createCustomEvent(EVENT_TYPES.DRAG_END)
-	    dragEndEvent.dataTransfer = dragStartEvent.dataTransfer
-	    dispatchEvent(sourceNode, EVENT_TYPES.DRAG_END, dragEndEvent)
+// parseTagExpression parses expressions like "smoke,login+!slow": ',' separates alternatives,
+// '+' joins terms that must all hold and '!' negates a term
+func parseTagExpression(expr string) (tagExpression, error) {
+	var tags tagExpression
+	for _, alternative := range strings.Split(expr, ",") {
+		var terms []tagTerm
+		for _, term := range strings.Split(alternative, "+") {
+			term = strings.TrimSpace(term)
+			negated := strings.HasPrefix(term, "!")
+			term = strings.TrimSpace(strings.TrimPrefix(term, "!"))
+			if term == "" {
+				return nil, fmt.Errorf("empty tag in '%s'", expr)
+			}
+			terms = append(terms, tagTerm{tag: term, negated: negated})
+		}
+		tags = append(tags, terms)
 	}
-	simulateDragDrop(arguments[0], arguments[1])
-	`
-	_, err = ctx.WebDriver.ExecuteScript(script, []interface{}{sourceElem, targetElem})
-	return err
+	return tags, nil
 }
 
-func switchToFrame(ctx *Context, step Step) error {
-	if step.Selector == "" {
-		return errors.New("switch_to_frame action requires 'selector' for the iframe")
+// Match reports whether a step with stepTags is selected
+func (e tagExpression) Match(stepTags []string) bool {
+	has := make(map[string]bool, len(stepTags))
+	for _, tag := range stepTags {
+		has[tag] = true
 	}
-	elem, err := findElement(ctx, step.Selector, step.Timeout)
-	if err != nil {
-		return err
+	for _, terms := range e {
+		matched := true
+		for _, term := range terms {
+			if has[term.tag] == term.negated {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
 	}
-	return ctx.WebDriver.SwitchFrame(elem)
+	return false
 }
 
-func switchToDefaultContent(ctx *Context) error {
-	return ctx.WebDriver.SwitchFrame("")
+// secretPrefix marks variables whose values are always masked in output
+const secretPrefix = "secret_"
+
+// maskSecrets replaces the values of secret variables in s with ***
+func maskSecrets(ctx *Context, s string) string {
+	for name, value := range ctx.Variables {
+		if value == "" || !(strings.HasPrefix(name, secretPrefix) || ctx.Masked[name]) {
+			continue
+		}
+		s = strings.ReplaceAll(s, value, "***")
+	}
+	return s
 }
 
-func closeBrowser(ctx *Context) error {
-	return ctx.WebDriver.Close()
+// maskingWriter masks secret variable values in everything written through it
+type maskingWriter struct {
+	ctx *Context
+	w   io.Writer
 }
 
-func quitBrowser(ctx *Context) error {
-	return ctx.WebDriver.Quit()
+func (m *maskingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(m.w, maskSecrets(m.ctx, string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
 
-func assertTitle(ctx *Context, step Step) error {
-	expected := step.ExpectedValue
-	if expected == "" {
-		return errors.New("assert_title action requires 'expected_value'")
+// stepLabel names a step for logs and reports, preferring its description over the bare action
+func stepLabel(step Step) string {
+	if step.Description == "" {
+		return step.Action
 	}
-	title, err := ctx.WebDriver.Title()
-	if err != nil {
-		return err
+	return fmt.Sprintf("%s [%s]", step.Description, step.Action)
+}
+
+// stepLocation describes where a step came from, e.g. "login.json:12 step[3].then[1]"
+func stepLocation(step Step) string {
+	return strings.TrimSpace(step.Source + " " + step.Path)
+}
+
+// browserSkipReason explains why step must not run on ctx.Browser according to its 'only_on' and
+// 'skip_on' lists, or returns "" if it should run
+func browserSkipReason(ctx *Context, step Step) string {
+	listed := func(browsers []string) bool {
+		for _, browser := range browsers {
+			if strings.EqualFold(browser, ctx.Browser) {
+				return true
+			}
+		}
+		return false
 	}
-	if title != expected {
-		return fmt.Errorf("title assertion failed: expected '%s', got '%s'", expected, title)
+	if len(step.OnlyOn) > 0 && !listed(step.OnlyOn) {
+		return fmt.Sprintf("only runs on %s", strings.Join(step.OnlyOn, ", "))
 	}
-	return nil
+	if listed(step.SkipOn) {
+		return fmt.Sprintf("skipped on %s", ctx.Browser)
+	}
+	return ""
 }
 
-func assertElementPresent(ctx *Context, step Step) error {
-	if step.Selector == "" {
-		return errors.New("assert_element_present action requires 'selector'")
+// runNestedSteps executes steps embedded in parent's params under name, tagging each with its
+// provenance so failures point at the nested position
+func runNestedSteps(ctx *Context, parent Step, name string, steps []Step) error {
+	for idx, nested := range steps {
+		nested.Source = parent.Source
+		nested.Path = fmt.Sprintf("%s.%s[%d]", parent.Path, name, idx)
+		if reason := browserSkipReason(ctx, nested); reason != "" {
+			fmt.Fprintf(console, "Skipping nested step %s: %s (%s)\n", nested.Path, stepLabel(nested), reason)
+			continue
+		}
+		fmt.Fprintf(console, "Executing nested step %s: %s\n", nested.Path, stepLabel(nested))
+		if err := executeStep(ctx, nested); err != nil && !errors.Is(err, errStepSkipped) {
+			return fmt.Errorf("%s (%s): %w", stepLocation(nested), stepLabel(nested), err)
+		}
 	}
-	_, err := findElement(ctx, step.Selector, step.Timeout)
+	return nil
+}
+
+// nestedSteps converts a steps array embedded in params back into Steps
+func nestedSteps(value interface{}) ([]Step, error) {
+	data, err := json.Marshal(value)
 	if err != nil {
-		return fmt.Errorf("element '%s' not found", step.Selector)
+		return nil, err
 	}
-	return nil
+	var steps []Step
+	if err := json.Unmarshal(data, &steps); err != nil {
+		return nil, err
+	}
+	return steps, nil
 }
 
-func printMessage(ctx *Context, step Step) error {
-	message := step.Message
-	// Replace placeholders with variable values
-	for key, value := range ctx.Variables {
-		placeholder := fmt.Sprintf("{{%s}}", key)
-		message = strings.ReplaceAll(message, placeholder, value)
+// performPointerActions performs a W3C pointer action sequence with a pointer of pointerType
+// ("mouse", "pen" or "touch") and releases the pointer afterwards
+func performPointerActions(ctx *Context, pointerType string, actions ...interface{}) error {
+	sequence := map[string]interface{}{
+		"actions": []interface{}{
+			map[string]interface{}{
+				"type":       "pointer",
+				"id":         pointerType,
+				"parameters": map[string]string{"pointerType": pointerType},
+				"actions":    actions,
+			},
+		},
+	}
+	if _, err := w3cCommand(ctx, http.MethodPost, "/actions", sequence); err != nil {
+		return err
+	}
+	if _, err := w3cCommand(ctx, http.MethodDelete, "/actions", nil); err != nil {
+		return fmt.Errorf("failed to release actions: %v", err)
 	}
-	fmt.Println(message)
 	return nil
 }
 
-// Helper Functions
+// webElementIdentifier is the key of the W3C element reference object
+const webElementIdentifier = "element-6066-11e4-a52e-4f735466cecf"
 
-// findElement locates an element using the provided selector and waits up to timeout seconds
-func findElement(ctx *Context, selector string, timeout int) (selenium.WebElement, error) {
-	if selector == "" {
-		return nil, errors.New("selector is required to find an element")
+// elementReference returns the W3C element reference for elem, as used by raw commands
+func elementReference(elem selenium.WebElement) (map[string]string, error) {
+	data, err := json.Marshal(elem)
+	if err != nil {
+		return nil, err
 	}
-	waitTimeout := time.Duration(timeout) * time.Second
-	endTime := time.Now().Add(waitTimeout)
+	var ref map[string]string
+	if err := json.Unmarshal(data, &ref); err != nil {
+		return nil, err
+	}
+	id := ref[webElementIdentifier]
+	if id == "" {
+		return nil, errors.New("element has no W3C identifier")
+	}
+	return map[string]string{webElementIdentifier: id}, nil
+}
 
-	for {
-		elem, err := ctx.WebDriver.FindElement(selenium.ByCSSSelector, selector)
-		if err == nil {
-			return elem, nil
-		}
-		if time.Now().After(endTime) {
-			return nil, fmt.Errorf("element with selector '%s' not found after %d seconds", selector, timeout)
+// w3cCommand sends a raw command for the current session to the WebDriver server and returns the
+// reply's value, for endpoints the selenium package doesn't wrap
+func w3cCommand(ctx *Context, method, path string, body interface{}) (json.RawMessage, error) {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
 		}
-		time.Sleep(500 * time.Millisecond)
+		payload = bytes.NewReader(data)
 	}
-}
+	url := fmt.Sprintf("%s/session/%s%s", ctx.URL, ctx.WebDriver.SessionID(), path)
+	request, err := http.NewRequest(method, url, payload)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	response, err := selenium.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
 
-func First[T any](t ...T) T {
-	var defaultVal T
-	for _, v := range t {
-		return v
+	var reply struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("invalid reply from %s %s (%s): %v", method, path, response.Status, err)
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		var failure struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(reply.Value, &failure); err == nil && failure.Error != "" {
+			return nil, fmt.Errorf("%s: %s", failure.Error, failure.Message)
+		}
+		return nil, fmt.Errorf("%s %s failed: %s", method, path, response.Status)
 	}
-	return defaultVal
+	return reply.Value, nil
 }